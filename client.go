@@ -4,28 +4,41 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/modfin/twofer/internal/serveid"
+	"github.com/modfin/twofer/internal/servmagic"
+	"github.com/modfin/twofer/internal/servoob"
 	"github.com/modfin/twofer/internal/servotp"
 	"github.com/modfin/twofer/internal/servpwd"
 	"github.com/modfin/twofer/internal/servqr"
+	"github.com/modfin/twofer/internal/servrecovery"
+	"github.com/modfin/twofer/internal/servw6n"
 	"io"
 	"net/http"
 )
 
 type Client struct {
-	EID *EidClient
-	Pwd *PwdClient
-	Otp *OtpClient
-	Qr  *QrClient
+	EID      *EidClient
+	Pwd      *PwdClient
+	Otp      *OtpClient
+	Qr       *QrClient
+	Recovery *RecoveryClient
+	Oob      *OobClient
+	Magic    *MagicLinkClient
+	WebAuthn *WebAuthnClient
 }
 
 func NewClient(baseurl string) Client {
 	return Client{
-		EID: NewEidClient(baseurl),
-		Pwd: NewPwdClient(baseurl),
-		Otp: NewOtpClient(baseurl),
-		Qr:  NewQrClient(baseurl),
+		EID:      NewEidClient(baseurl),
+		Pwd:      NewPwdClient(baseurl),
+		Otp:      NewOtpClient(baseurl),
+		Qr:       NewQrClient(baseurl),
+		Recovery: NewRecoveryClient(baseurl),
+		Oob:      NewOobClient(baseurl),
+		Magic:    NewMagicLinkClient(baseurl),
+		WebAuthn: NewWebAuthnClient(baseurl),
 	}
 }
 
@@ -293,6 +306,147 @@ func (c *OtpClient) Auth(ctx context.Context, req *servotp.Credentials) (servotp
 	return userAuthResponse, nil
 }
 
+func (c *OtpClient) Resync(ctx context.Context, req *servotp.Resync) (servotp.AuthResponse, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servotp.AuthResponse{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/otp/resync")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servotp.AuthResponse{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servotp.AuthResponse{}, err
+	}
+	var userAuthResponse servotp.AuthResponse
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servotp.AuthResponse{}, err
+	}
+	err = json.Unmarshal(b, &userAuthResponse)
+	if err != nil {
+		return servotp.AuthResponse{}, err
+	}
+	return userAuthResponse, nil
+}
+
+func (c *OtpClient) Import(ctx context.Context, req *servotp.Import) (servotp.EnrollmentResponse, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servotp.EnrollmentResponse{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/otp/import")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servotp.EnrollmentResponse{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servotp.EnrollmentResponse{}, err
+	}
+	var userEnrollmentResponse servotp.EnrollmentResponse
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servotp.EnrollmentResponse{}, err
+	}
+	err = json.Unmarshal(b, &userEnrollmentResponse)
+	if err != nil {
+		return servotp.EnrollmentResponse{}, err
+	}
+	return userEnrollmentResponse, nil
+}
+
+// ImportBulk sends all imports as a NDJSON stream and returns the result for each of them, in the same order
+func (c *OtpClient) ImportBulk(ctx context.Context, req []servotp.Import) ([]servotp.BulkResult, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, im := range req {
+		err := enc.Encode(im)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.bulk(ctx, "v1/otp/import/bulk", &buf)
+}
+
+func (c *OtpClient) Upgrade(ctx context.Context, req *servotp.Blob) (servotp.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servotp.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/otp/upgrade")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servotp.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servotp.Blob{}, err
+	}
+	var blob servotp.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servotp.Blob{}, err
+	}
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return servotp.Blob{}, err
+	}
+	return blob, nil
+}
+
+// UpgradeBulk sends all blobs as a NDJSON stream and returns the result for each of them, in the same order
+func (c *OtpClient) UpgradeBulk(ctx context.Context, req []servotp.Blob) ([]servotp.BulkResult, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, blob := range req {
+		err := enc.Encode(blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.bulk(ctx, "v1/otp/upgrade/bulk", &buf)
+}
+
+// bulk posts a NDJSON stream to path and decodes the NDJSON stream of results
+func (c *OtpClient) bulk(ctx context.Context, path string, body io.Reader) ([]servotp.BulkResult, error) {
+	u := fmt.Sprintf("%s/%s", c.baseUrl, path)
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/x-json-stream")
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unsuccessful bulk request, status code: %d", resp.StatusCode)
+	}
+	var results []servotp.BulkResult
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var res servotp.BulkResult
+		err = dec.Decode(&res)
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+}
+
 func (c *OtpClient) GetQRImage(ctx context.Context, req *servotp.Credentials) (servqr.Image, error) {
 	bs, err := json.Marshal(req)
 	if err != nil {
@@ -321,6 +475,36 @@ func (c *OtpClient) GetQRImage(ctx context.Context, req *servotp.Credentials) (s
 	return qrImage, nil
 }
 
+// GetQR is like GetQRImage but allows for size, recovery level and format of the image to be set, and can include the
+// secret for manual entry
+func (c *OtpClient) GetQR(ctx context.Context, req *servotp.QRReq) (servotp.QRRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servotp.QRRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/otp/qr")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servotp.QRRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servotp.QRRes{}, err
+	}
+	var qrRes servotp.QRRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servotp.QRRes{}, err
+	}
+	err = json.Unmarshal(b, &qrRes)
+	if err != nil {
+		return servotp.QRRes{}, err
+	}
+	return qrRes, nil
+}
+
 type PwdClient struct {
 	c       *http.Client
 	baseUrl string
@@ -361,6 +545,34 @@ func (c *PwdClient) Enroll(ctx context.Context, req *servpwd.EnrollReq) (servpwd
 	return userBlob, nil
 }
 
+func (c *PwdClient) Import(ctx context.Context, req *servpwd.ImportReq) (servpwd.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servpwd.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/pwd/import")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servpwd.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servpwd.Blob{}, err
+	}
+	var userBlob servpwd.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servpwd.Blob{}, err
+	}
+	err = json.Unmarshal(b, &userBlob)
+	if err != nil {
+		return servpwd.Blob{}, err
+	}
+	return userBlob, nil
+}
+
 func (c *PwdClient) Auth(ctx context.Context, req *servpwd.AuthReq) (servpwd.Res, error) {
 	bs, err := json.Marshal(req)
 	if err != nil {
@@ -389,6 +601,34 @@ func (c *PwdClient) Auth(ctx context.Context, req *servpwd.AuthReq) (servpwd.Res
 	return userRes, nil
 }
 
+func (c *PwdClient) Change(ctx context.Context, req *servpwd.ChangeReq) (servpwd.ChangeRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servpwd.ChangeRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/pwd/change")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servpwd.ChangeRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servpwd.ChangeRes{}, err
+	}
+	var changeRes servpwd.ChangeRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servpwd.ChangeRes{}, err
+	}
+	err = json.Unmarshal(b, &changeRes)
+	if err != nil {
+		return servpwd.ChangeRes{}, err
+	}
+	return changeRes, nil
+}
+
 type QrClient struct {
 	c       *http.Client
 	baseUrl string
@@ -424,3 +664,471 @@ func (c *QrClient) GetQrData(ctx context.Context, uriBody string) (servqr.QRData
 	}
 	return qrData, nil
 }
+
+type RecoveryClient struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func NewRecoveryClient(baseurl string) *RecoveryClient {
+	return &RecoveryClient{
+		c:       http.DefaultClient,
+		baseUrl: baseurl,
+	}
+}
+
+func (c *RecoveryClient) Enroll(ctx context.Context, req *servrecovery.EnrollReq) (servrecovery.EnrollRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servrecovery.EnrollRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/recovery/enroll")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servrecovery.EnrollRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servrecovery.EnrollRes{}, err
+	}
+	var enrollRes servrecovery.EnrollRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servrecovery.EnrollRes{}, err
+	}
+	err = json.Unmarshal(b, &enrollRes)
+	if err != nil {
+		return servrecovery.EnrollRes{}, err
+	}
+	return enrollRes, nil
+}
+
+func (c *RecoveryClient) Auth(ctx context.Context, req *servrecovery.AuthReq) (servrecovery.AuthRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servrecovery.AuthRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/recovery/auth")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servrecovery.AuthRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servrecovery.AuthRes{}, err
+	}
+	var authRes servrecovery.AuthRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servrecovery.AuthRes{}, err
+	}
+	err = json.Unmarshal(b, &authRes)
+	if err != nil {
+		return servrecovery.AuthRes{}, err
+	}
+	return authRes, nil
+}
+
+func (c *RecoveryClient) Upgrade(ctx context.Context, req *servrecovery.Blob) (servrecovery.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servrecovery.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/recovery/upgrade")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servrecovery.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servrecovery.Blob{}, err
+	}
+	var blob servrecovery.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servrecovery.Blob{}, err
+	}
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return servrecovery.Blob{}, err
+	}
+	return blob, nil
+}
+
+type OobClient struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func NewOobClient(baseurl string) *OobClient {
+	return &OobClient{
+		c:       http.DefaultClient,
+		baseUrl: baseurl,
+	}
+}
+
+func (c *OobClient) Challenge(ctx context.Context, req *servoob.ChallengeReq) (servoob.ChallengeRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servoob.ChallengeRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/oob/challenge")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servoob.ChallengeRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servoob.ChallengeRes{}, err
+	}
+	var challengeRes servoob.ChallengeRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servoob.ChallengeRes{}, err
+	}
+	err = json.Unmarshal(b, &challengeRes)
+	if err != nil {
+		return servoob.ChallengeRes{}, err
+	}
+	return challengeRes, nil
+}
+
+func (c *OobClient) Verify(ctx context.Context, req *servoob.VerifyReq) (servoob.VerifyRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servoob.VerifyRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/oob/verify")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servoob.VerifyRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servoob.VerifyRes{}, err
+	}
+	var verifyRes servoob.VerifyRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servoob.VerifyRes{}, err
+	}
+	err = json.Unmarshal(b, &verifyRes)
+	if err != nil {
+		return servoob.VerifyRes{}, err
+	}
+	return verifyRes, nil
+}
+
+type MagicLinkClient struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func NewMagicLinkClient(baseurl string) *MagicLinkClient {
+	return &MagicLinkClient{
+		c:       http.DefaultClient,
+		baseUrl: baseurl,
+	}
+}
+
+func (c *MagicLinkClient) Issue(ctx context.Context, req *servmagic.IssueReq) (servmagic.IssueRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servmagic.IssueRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/magiclink/issue")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servmagic.IssueRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servmagic.IssueRes{}, err
+	}
+	var issueRes servmagic.IssueRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servmagic.IssueRes{}, err
+	}
+	err = json.Unmarshal(b, &issueRes)
+	if err != nil {
+		return servmagic.IssueRes{}, err
+	}
+	return issueRes, nil
+}
+
+func (c *MagicLinkClient) Verify(ctx context.Context, req *servmagic.VerifyReq) (servmagic.VerifyRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servmagic.VerifyRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/magiclink/verify")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servmagic.VerifyRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servmagic.VerifyRes{}, err
+	}
+	var verifyRes servmagic.VerifyRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servmagic.VerifyRes{}, err
+	}
+	err = json.Unmarshal(b, &verifyRes)
+	if err != nil {
+		return servmagic.VerifyRes{}, err
+	}
+	return verifyRes, nil
+}
+
+type WebAuthnClient struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func NewWebAuthnClient(baseurl string) *WebAuthnClient {
+	return &WebAuthnClient{
+		c:       http.DefaultClient,
+		baseUrl: baseurl,
+	}
+}
+
+func (c *WebAuthnClient) EnrollInit(ctx context.Context, req *servw6n.EnrollInitReq) (servw6n.InitRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/enroll/init")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	var initRes servw6n.InitRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	err = json.Unmarshal(b, &initRes)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	return initRes, nil
+}
+
+func (c *WebAuthnClient) EnrollFinal(ctx context.Context, req *servw6n.FinalReq) (servw6n.FinalRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/enroll/final")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	var finalRes servw6n.FinalRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	err = json.Unmarshal(b, &finalRes)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	return finalRes, nil
+}
+
+func (c *WebAuthnClient) AuthInit(ctx context.Context, req *servw6n.AuthInitReq) (servw6n.InitRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/auth/init")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	var initRes servw6n.InitRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	err = json.Unmarshal(b, &initRes)
+	if err != nil {
+		return servw6n.InitRes{}, err
+	}
+	return initRes, nil
+}
+
+func (c *WebAuthnClient) AuthFinal(ctx context.Context, req *servw6n.FinalReq) (servw6n.FinalRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/auth/final")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	var finalRes servw6n.FinalRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	err = json.Unmarshal(b, &finalRes)
+	if err != nil {
+		return servw6n.FinalRes{}, err
+	}
+	return finalRes, nil
+}
+
+func (c *WebAuthnClient) Upgrade(ctx context.Context, req *servw6n.Blob) (servw6n.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/upgrade")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	var blob servw6n.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	return blob, nil
+}
+
+func (c *WebAuthnClient) Credentials(ctx context.Context, req *servw6n.Blob) (servw6n.CredentialsRes, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.CredentialsRes{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/credentials")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.CredentialsRes{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.CredentialsRes{}, err
+	}
+	var credentials servw6n.CredentialsRes
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.CredentialsRes{}, err
+	}
+	err = json.Unmarshal(b, &credentials)
+	if err != nil {
+		return servw6n.CredentialsRes{}, err
+	}
+	return credentials, nil
+}
+
+func (c *WebAuthnClient) RenameCredential(ctx context.Context, req *servw6n.RenameReq) (servw6n.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/credentials/rename")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	var blob servw6n.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	return blob, nil
+}
+
+func (c *WebAuthnClient) RemoveCredential(ctx context.Context, req *servw6n.RemoveReq) (servw6n.Blob, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	buf := bytes.NewBuffer(bs)
+
+	u := fmt.Sprintf("%s/%s", c.baseUrl, "v1/webauthn/credentials/remove")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	resp, err := c.c.Do(hreq)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	var blob servw6n.Blob
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	err = json.Unmarshal(b, &blob)
+	if err != nil {
+		return servw6n.Blob{}, err
+	}
+	return blob, nil
+}
@@ -17,9 +17,14 @@ import (
 	"github.com/modfin/twofer/internal/eid/bankid"
 	"github.com/modfin/twofer/internal/httpserve"
 	"github.com/modfin/twofer/internal/ordertoken"
+	"github.com/modfin/twofer/internal/replay"
+	"github.com/modfin/twofer/internal/servmagic"
+	"github.com/modfin/twofer/internal/servoob"
 	"github.com/modfin/twofer/internal/servotp"
 	"github.com/modfin/twofer/internal/servpwd"
 	"github.com/modfin/twofer/internal/servqr"
+	"github.com/modfin/twofer/internal/servrecovery"
+	"github.com/modfin/twofer/internal/servw6n"
 	"github.com/modfin/twofer/stream/ndjson"
 	"github.com/modfin/twofer/stream/sse"
 )
@@ -40,11 +45,7 @@ func main() {
 
 	if cfg.OTP.Enabled {
 		fmt.Println("- Enabling OTP")
-		otpserv, err := servotp.New(servotp.OTPConfig{
-			SkewCounter: config.Get().OTP.SkewCounter,
-			SkewTime:    config.Get().OTP.SkewTime,
-			RateLimit:   config.Get().OTP.RateLimit,
-		}, config.Get().OTP.EncryptionKey)
+		otpserv, err := newOTPServer(cfg.OTP)
 		if err == nil {
 			fmt.Println("  - Serving OTP via HTTP")
 			httpserve.RegisterOTPServer(e, otpserv)
@@ -65,28 +66,23 @@ func main() {
 	}
 
 	if cfg.WebAuthn.Enabled {
-		/*
-			fmt.Println("- Enabling WebAuthn")
-			authn, err := servw6n.New(cfg.WebAuthn)
-			if err != nil {
-				fmt.Println("WebAuthn", err)
-			} else {
-				// TODO: gw6n.RegisterWebAuthnServer(grpcServer, authn)
-			}
-		*/
+		fmt.Println("- Enabling WebAuthn")
+		var consumed replay.Cache
+		if cfg.WebAuthn.SingleUseSessions {
+			consumed = replay.NewMemory()
+		}
+		authn, err := servw6n.New(cfg.WebAuthn, consumed)
+		if err != nil {
+			fmt.Println("WebAuthn", err)
+		} else {
+			fmt.Println("  - Serving WebAuthn via HTTP")
+			httpserve.RegisterWebAuthnServer(e, authn)
+		}
 	}
 
 	if cfg.PWD.Enabled {
 		fmt.Println("- Enabling PWD")
-		_servpwd, err := servpwd.New(servpwd.PWDConfig{
-			DefaultAlg:          servpwd.Alg(cfg.PWD.DefaultAlg),
-			DefaultHashCount:    cfg.PWD.DefaultHashCount,
-			DefaultBCryptCost:   cfg.PWD.DefaultBCryptCost,
-			DefaultSCryptN:      cfg.PWD.DefaultSCryptN,
-			DefaultSCryptR:      cfg.PWD.DefaultSCryptR,
-			DefaultSCryptP:      cfg.PWD.DefaultSCryptP,
-			DefaultSCryptKeyLen: cfg.PWD.DefaultSCryptKeyLen,
-		}, cfg.PWD.EncryptionKey)
+		_servpwd, err := newPWDServer(cfg.PWD)
 		if err == nil {
 			fmt.Println("  - Serving PWD via HTTP")
 			httpserve.RegisterPWDServer(e, _servpwd)
@@ -95,9 +91,156 @@ func main() {
 		}
 	}
 
+	if cfg.Recovery.Enabled {
+		fmt.Println("- Enabling Recovery codes")
+		_servrecovery, err := servrecovery.New(servrecovery.RecoveryConfig{
+			Count:     cfg.Recovery.Count,
+			Length:    cfg.Recovery.Length,
+			RateLimit: cfg.Recovery.RateLimit,
+		}, cfg.Recovery.EncryptionKey)
+		if err == nil {
+			fmt.Println("  - Serving Recovery codes via HTTP")
+			httpserve.RegisterRecoveryServer(e, _servrecovery)
+		} else {
+			fmt.Println("Could not enable Recovery codes", err)
+		}
+	}
+
+	if cfg.OOB.Enabled {
+		fmt.Println("- Enabling OOB")
+		_servoob, err := newOOBServer(cfg.OOB)
+		if err == nil {
+			fmt.Println("  - Serving OOB via HTTP")
+			httpserve.RegisterOOBServer(e, _servoob)
+		} else {
+			fmt.Println("Could not enable OOB", err)
+		}
+	}
+
+	if cfg.MagicLink.Enabled {
+		fmt.Println("- Enabling Magic link")
+		_servmagic, err := servmagic.New(servmagic.MagicConfig{
+			URLTemplate: cfg.MagicLink.URLTemplate,
+			TTL:         cfg.MagicLink.TTL,
+			MaxTTL:      cfg.MagicLink.MaxTTL,
+		}, cfg.MagicLink.JwtEc256, cfg.MagicLink.JwtEc256Pub, cfg.MagicLink.EncryptionKey, nil)
+		if err == nil {
+			fmt.Println("  - Serving Magic link via HTTP")
+			httpserve.RegisterMagicLinkServer(e, _servmagic)
+		} else {
+			fmt.Println("Could not enable Magic link", err)
+		}
+	}
+
 	startServer(e)
 }
 
+func newPWDServer(cfg config.PWD) (*servpwd.Server, error) {
+	conf := servpwd.PWDConfig{
+		DefaultAlg:          servpwd.Alg(cfg.DefaultAlg),
+		DefaultHashCount:    cfg.DefaultHashCount,
+		DefaultBCryptCost:   cfg.DefaultBCryptCost,
+		DefaultSCryptN:      cfg.DefaultSCryptN,
+		DefaultSCryptR:      cfg.DefaultSCryptR,
+		DefaultSCryptP:      cfg.DefaultSCryptP,
+		DefaultSCryptKeyLen: cfg.DefaultSCryptKeyLen,
+
+		DefaultArgon2Memory:      cfg.DefaultArgon2Memory,
+		DefaultArgon2Time:        cfg.DefaultArgon2Time,
+		DefaultArgon2Parallelism: cfg.DefaultArgon2Parallelism,
+		DefaultArgon2KeyLen:      cfg.DefaultArgon2KeyLen,
+
+		MinLength:         cfg.MinLength,
+		MaxLength:         cfg.MaxLength,
+		ContextWords:      cfg.ContextWords,
+		HistorySize:       cfg.HistorySize,
+		BreachedThreshold: cfg.BreachedThreshold,
+
+		Peppers:       cfg.Pepper,
+		MaxConcurrent: cfg.MaxConcurrent,
+	}
+	switch {
+	case cfg.BreachedRangeDir != "":
+		conf.Breached = servpwd.RangeDir(cfg.BreachedRangeDir)
+	case cfg.BreachedRangeAPI != "":
+		conf.Breached = servpwd.NewRangeAPI(cfg.BreachedRangeAPI, cfg.BreachedTimeout)
+	}
+	return servpwd.New(conf, cfg.EncryptionKey)
+}
+
+func newOTPServer(cfg config.OTP) (*servotp.Server, error) {
+	conf := servotp.OTPConfig{
+		SkewCounter: cfg.SkewCounter,
+		SkewTime:    cfg.SkewTime,
+		RateLimit:   cfg.RateLimit,
+
+		ResyncCounter: cfg.ResyncCounter,
+		ResyncTime:    cfg.ResyncTime,
+
+		MaxSkewCounter:   cfg.MaxSkewCounter,
+		MaxSkewTime:      cfg.MaxSkewTime,
+		ReplayProtection: cfg.ReplayProtection,
+		AllowedPeriods:   cfg.AllowedPeriods,
+
+		LockoutThreshold: cfg.LockoutThreshold,
+		LockoutBase:      cfg.LockoutBase,
+		LockoutMax:       cfg.LockoutMax,
+	}
+	for _, a := range cfg.AllowedAlgs {
+		v, ok := servotp.Alg_value[a]
+		if !ok {
+			return nil, fmt.Errorf("unknown otp alg %s", a)
+		}
+		conf.AllowedAlgs = append(conf.AllowedAlgs, servotp.Alg(v))
+	}
+	for _, d := range cfg.AllowedDigits {
+		v, ok := servotp.Digits_value[d]
+		if !ok {
+			return nil, fmt.Errorf("unknown otp digits %s", d)
+		}
+		conf.AllowedDigits = append(conf.AllowedDigits, servotp.Digits(v))
+	}
+	return servotp.New(conf, cfg.EncryptionKey)
+}
+
+func newOOBServer(cfg config.OOB) (*servoob.Server, error) {
+	senders := map[string]servoob.Sender{}
+	if cfg.SMTPHost != "" {
+		fmt.Println("  - Sending email via SMTP")
+		smtpSender, err := servoob.NewSMTPSender(servoob.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			Subject:  cfg.SMTPSubject,
+			Body:     cfg.SMTPBody,
+		})
+		if err != nil {
+			return nil, err
+		}
+		senders["email"] = smtpSender
+	}
+	if cfg.WebhookURL != "" {
+		fmt.Println("  - Sending sms via webhook")
+		webhookSender, err := servoob.NewWebhookSender(servoob.WebhookConfig{
+			URL:     cfg.WebhookURL,
+			Secret:  cfg.WebhookSecret,
+			Timeout: cfg.WebhookTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		senders["sms"] = webhookSender
+	}
+	return servoob.New(servoob.OOBConfig{
+		CodeLength:  cfg.CodeLength,
+		TTL:         cfg.TTL,
+		MaxAttempts: cfg.MaxAttempts,
+		RateLimit:   cfg.RateLimit,
+	}, cfg.EncryptionKey, senders, nil)
+}
+
 func startServer(e *echo.Echo) {
 	appCtx, appClose := context.WithCancel(context.Background())
 	go func() {
@@ -19,6 +19,9 @@ type Config struct {
 	OTP       OTP
 	WebAuthn  WebAuthn
 	PWD       PWD
+	Recovery  Recovery
+	OOB       OOB
+	MagicLink MagicLink
 
 	StreamEncoder string `env:"STREAM_ENCODER" envDefault:"SSE"`
 }
@@ -33,6 +36,19 @@ type OTP struct {
 	RateLimit     uint     `env:"OTP_RATE_LIMIT" envDefault:"10"`
 	SkewCounter   uint     `env:"OTP_SKEW_COUNTER" envDefault:"5"`
 	SkewTime      uint     `env:"OTP_SKEW_TIME" envDefault:"1"`
+	ResyncCounter uint     `env:"OTP_RESYNC_COUNTER" envDefault:"100"`
+	ResyncTime    uint     `env:"OTP_RESYNC_TIME" envDefault:"10"`
+
+	MaxSkewCounter   uint     `env:"OTP_MAX_SKEW_COUNTER"`
+	MaxSkewTime      uint     `env:"OTP_MAX_SKEW_TIME"`
+	ReplayProtection bool     `env:"OTP_REPLAY_PROTECTION" envDefault:"FALSE"`
+	AllowedAlgs      []string `env:"OTP_ALLOWED_ALGS" envSeparator:" "`
+	AllowedDigits    []string `env:"OTP_ALLOWED_DIGITS" envSeparator:" "`
+	AllowedPeriods   []uint32 `env:"OTP_ALLOWED_PERIODS" envSeparator:" "`
+
+	LockoutThreshold uint          `env:"OTP_LOCKOUT_THRESHOLD" envDefault:"5"`
+	LockoutBase      time.Duration `env:"OTP_LOCKOUT_BASE" envDefault:"30s"`
+	LockoutMax       time.Duration `env:"OTP_LOCKOUT_MAX" envDefault:"1h"`
 }
 
 type BankID struct {
@@ -70,21 +86,35 @@ func (b BankID) GetClientKey() []byte {
 }
 
 type WebAuthn struct {
-	Enabled          bool   `env:"WEBAUTHN_ENABLED" envDefault:"FALSE"`
-	RPDisplayName    string `env:"WEBAUTHN_RP_DISPLAYNAME"`
-	RPID             string `env:"WEBAUTHN_RP_ID"`
-	RPOrigin         string `env:"WEBAUTHN_RP_ORIGIN"`
-	HMACKey          string `env:"WEBAUTHN_HMAC_KEY"`
-	UserVerification string `env:"WEBAUTHN_USER_VERIFICATION" envDefault:"discouraged"`
+	Enabled          bool     `env:"WEBAUTHN_ENABLED" envDefault:"FALSE"`
+	RPDisplayName    string   `env:"WEBAUTHN_RP_DISPLAYNAME"`
+	RPID             string   `env:"WEBAUTHN_RP_ID"`
+	RPOrigin         string   `env:"WEBAUTHN_RP_ORIGIN"`
+	RPOrigins        []string `env:"WEBAUTHN_RP_ORIGINS" envSeparator:" "`
+	RPTopOrigins     []string `env:"WEBAUTHN_RP_TOP_ORIGINS" envSeparator:" "`
+	HMACKey          string   `env:"WEBAUTHN_HMAC_KEY"`
+	EncryptionKey    []string `env:"WEBAUTHN_ENCRYPTION_KEY" envSeparator:" "`
+	UserVerification string   `env:"WEBAUTHN_USER_VERIFICATION" envDefault:"discouraged"`
+	ResidentKey      string   `env:"WEBAUTHN_RESIDENT_KEY" envDefault:"discouraged"`
+	Attestation      string   `env:"WEBAUTHN_ATTESTATION" envDefault:"none"`
+	RequireMetadata  bool     `env:"WEBAUTHN_REQUIRE_METADATA" envDefault:"FALSE"`
+	MDSBlob          string   `env:"WEBAUTHN_MDS_BLOB"`
+	MDSRoot          string   `env:"WEBAUTHN_MDS_ROOT"`
+	AAGUIDAllow      []string `env:"WEBAUTHN_AAGUID_ALLOW" envSeparator:" "`
+	AAGUIDDeny       []string `env:"WEBAUTHN_AAGUID_DENY" envSeparator:" "`
 
 	RateLimit uint          `env:"WEBAUTHN_RATE_LIMIT" envDefault:"10"`
 	Timeout   time.Duration `env:"WEBAUTHN_TIMEOUT" envDefault:"60s"`
+	// SingleUseSessions consumes the challenge of a session when it's finalized, a memory cache is used so it's only
+	// effective within one instance of twofer
+	SingleUseSessions bool `env:"WEBAUTHN_SINGLE_USE_SESSIONS" envDefault:"TRUE"`
 }
 
 type PWD struct {
 	Enabled       bool     `env:"PWD_ENABLE" envDefault:"FALSE"`
 	EncryptionKey []string `env:"PWD_ENCRYPTION_KEY" envSeparator:" "`
 	DefaultAlg    int32    `env:"PWD_ALG" envDefault:"0"`
+	Pepper        []string `env:"PWD_PEPPER" envSeparator:" "`
 
 	DefaultHashCount int `env:"PWD_HASH_COUNT" envDefault:"1"`
 
@@ -94,6 +124,62 @@ type PWD struct {
 	DefaultSCryptR      int `env:"PWD_SCRYPT_R" envDefault:"8"`
 	DefaultSCryptP      int `env:"PWD_SCRYPT_P" envDefault:"1"`
 	DefaultSCryptKeyLen int `env:"PWD_SCRYPT_KEY_LEN" envDefault:"32"`
+
+	DefaultArgon2Memory      uint32 `env:"PWD_ARGON2_MEMORY" envDefault:"19456"` // KiB
+	DefaultArgon2Time        uint32 `env:"PWD_ARGON2_TIME" envDefault:"2"`
+	DefaultArgon2Parallelism uint8  `env:"PWD_ARGON2_PARALLELISM" envDefault:"1"`
+	DefaultArgon2KeyLen      uint32 `env:"PWD_ARGON2_KEY_LEN" envDefault:"32"`
+
+	MinLength    int      `env:"PWD_MIN_LENGTH" envDefault:"8"`
+	MaxLength    int      `env:"PWD_MAX_LENGTH" envDefault:"64"`
+	ContextWords []string `env:"PWD_CONTEXT_WORDS" envSeparator:" "`
+	HistorySize  int      `env:"PWD_HISTORY_SIZE" envDefault:"5"`
+
+	MaxConcurrent int `env:"PWD_MAX_CONCURRENT"`
+
+	BreachedRangeDir  string        `env:"PWD_BREACHED_RANGE_DIR"`
+	BreachedRangeAPI  string        `env:"PWD_BREACHED_RANGE_API"`
+	BreachedTimeout   time.Duration `env:"PWD_BREACHED_TIMEOUT" envDefault:"5s"`
+	BreachedThreshold int           `env:"PWD_BREACHED_THRESHOLD" envDefault:"1"`
+}
+
+type Recovery struct {
+	Enabled       bool     `env:"RECOVERY_ENABLE" envDefault:"FALSE"`
+	EncryptionKey []string `env:"RECOVERY_ENCRYPTION_KEY" envSeparator:" "`
+	Count         uint32   `env:"RECOVERY_CODE_COUNT" envDefault:"10"`
+	Length        uint32   `env:"RECOVERY_CODE_LENGTH" envDefault:"10"`
+	RateLimit     uint     `env:"RECOVERY_RATE_LIMIT" envDefault:"10"`
+}
+
+type OOB struct {
+	Enabled       bool          `env:"OOB_ENABLE" envDefault:"FALSE"`
+	EncryptionKey []string      `env:"OOB_ENCRYPTION_KEY" envSeparator:" "`
+	CodeLength    int           `env:"OOB_CODE_LENGTH" envDefault:"6"`
+	TTL           time.Duration `env:"OOB_TTL" envDefault:"5m"`
+	MaxAttempts   uint32        `env:"OOB_MAX_ATTEMPTS" envDefault:"5"`
+	RateLimit     uint          `env:"OOB_RATE_LIMIT" envDefault:"3"`
+
+	SMTPHost     string `env:"OOB_SMTP_HOST"`
+	SMTPPort     int    `env:"OOB_SMTP_PORT" envDefault:"587"`
+	SMTPUsername string `env:"OOB_SMTP_USERNAME"`
+	SMTPPassword string `env:"OOB_SMTP_PASSWORD"`
+	SMTPFrom     string `env:"OOB_SMTP_FROM"`
+	SMTPSubject  string `env:"OOB_SMTP_SUBJECT"`
+	SMTPBody     string `env:"OOB_SMTP_BODY"`
+
+	WebhookURL     string        `env:"OOB_WEBHOOK_URL"`
+	WebhookSecret  string        `env:"OOB_WEBHOOK_SECRET"`
+	WebhookTimeout time.Duration `env:"OOB_WEBHOOK_TIMEOUT" envDefault:"10s"`
+}
+
+type MagicLink struct {
+	Enabled       bool          `env:"MAGICLINK_ENABLE" envDefault:"FALSE"`
+	EncryptionKey []string      `env:"MAGICLINK_ENCRYPTION_KEY" envSeparator:" "`
+	JwtEc256      string        `env:"MAGICLINK_JWT_EC_256"`
+	JwtEc256Pub   string        `env:"MAGICLINK_JWT_EC_256_PUB"`
+	URLTemplate   string        `env:"MAGICLINK_URL_TEMPLATE"`
+	TTL           time.Duration `env:"MAGICLINK_TTL" envDefault:"15m"`
+	MaxTTL        time.Duration `env:"MAGICLINK_MAX_TTL" envDefault:"24h"`
 }
 
 var once sync.Once
@@ -103,7 +103,9 @@ func (s *store) Encrypt(plaintext []byte) ([]byte, error) {
 }
 
 func (s *store) Decrypt(ciphertext []byte) ([]byte, error) {
-
+	if len(ciphertext) < 4 {
+		return nil, errors.New("ciphertext is too short")
+	}
 	version := binary.BigEndian.Uint32(ciphertext[:4])
 	key, ok := s.keys[version]
 	if !ok {
@@ -114,6 +116,9 @@ func (s *store) Decrypt(ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(ciphertext) < 4+aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
 
 	return aead.Open(nil, ciphertext[4:4+aead.NonceSize()], ciphertext[4+aead.NonceSize():], nil)
 }
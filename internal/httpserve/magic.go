@@ -0,0 +1,45 @@
+package httpserve
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/twofer/internal/servmagic"
+	"io"
+	"net/http"
+)
+
+func RegisterMagicLinkServer(e *echo.Echo, s *servmagic.Server) {
+	e.POST("/v1/magiclink/issue", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var issueReq servmagic.IssueReq
+		err = json.Unmarshal(b, &issueReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		issueRes, err := s.Issue(c.Request().Context(), &issueReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, issueRes)
+	})
+
+	e.POST("/v1/magiclink/verify", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var verifyReq servmagic.VerifyReq
+		err = json.Unmarshal(b, &verifyReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		verifyRes, err := s.Verify(c.Request().Context(), &verifyReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, verifyRes)
+	})
+}
@@ -0,0 +1,45 @@
+package httpserve
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/twofer/internal/servoob"
+	"io"
+	"net/http"
+)
+
+func RegisterOOBServer(e *echo.Echo, s *servoob.Server) {
+	e.POST("/v1/oob/challenge", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var challengeReq servoob.ChallengeReq
+		err = json.Unmarshal(b, &challengeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		challengeRes, err := s.Challenge(c.Request().Context(), &challengeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, challengeRes)
+	})
+
+	e.POST("/v1/oob/verify", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var verifyReq servoob.VerifyReq
+		err = json.Unmarshal(b, &verifyReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		verifyRes, err := s.Verify(c.Request().Context(), &verifyReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, verifyRes)
+	})
+}
@@ -1,9 +1,13 @@
 package httpserve
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/modfin/twofer/internal/servotp"
+	"github.com/modfin/twofer/stream/ndjson"
 	"io"
 	"net/http"
 )
@@ -43,20 +47,129 @@ func RegisterOTPServer(e *echo.Echo, s *servotp.Server) {
 		return c.JSON(http.StatusOK, authResp)
 	})
 
+	e.POST("/v1/otp/resync", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var re servotp.Resync
+		err = json.Unmarshal(b, &re)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		resyncResp, err := s.Resync(c.Request().Context(), &re)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, resyncResp)
+	})
+
+	e.POST("/v1/otp/import", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var im servotp.Import
+		err = json.Unmarshal(b, &im)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		importResp, err := s.Import(c.Request().Context(), &im)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, importResp)
+	})
+
+	e.POST("/v1/otp/import/bulk", otpBulk(func(ctx context.Context, im *servotp.Import) servotp.BulkResult {
+		res := servotp.BulkResult{Ref: im.Ref}
+		importResp, err := s.Import(ctx, im)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.UserBlob = importResp.UserBlob
+		return res
+	}))
+
+	e.POST("/v1/otp/upgrade", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var blob servotp.Blob
+		err = json.Unmarshal(b, &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		upgraded, err := s.Upgrade(c.Request().Context(), &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, upgraded)
+	})
+
+	e.POST("/v1/otp/upgrade/bulk", otpBulk(func(ctx context.Context, blob *servotp.Blob) servotp.BulkResult {
+		res := servotp.BulkResult{Ref: blob.Ref}
+		upgraded, err := s.Upgrade(ctx, blob)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.UserBlob = upgraded.UserBlob
+		return res
+	}))
+
 	e.POST("/v1/otp/qr", func(c echo.Context) error {
 		b, err := io.ReadAll(c.Request().Body)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, err)
 		}
-		var va servotp.Credentials
-		err = json.Unmarshal(b, &va)
+		var qrReq servotp.QRReq
+		err = json.Unmarshal(b, &qrReq)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, err)
 		}
-		qrImage, err := s.GetQRImage(c.Request().Context(), &va)
+		qrImage, err := s.GetQRImage(c.Request().Context(), &qrReq)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, err.Error())
 		}
 		return c.JSON(http.StatusOK, qrImage)
 	})
 }
+
+// otpBulk reads a NDJSON stream of T from the request and responds with a NDJSON stream of servotp.BulkResult, one for
+// each line in the request
+func otpBulk[T any](fn func(ctx context.Context, item *T) servotp.BulkResult) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Results are written while the request is still being read, which HTTP/1 doesn't allow unless enabled. HTTP/2
+		// always allows it and reports it as not supported
+		err := http.NewResponseController(c.Response().Writer).EnableFullDuplex()
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		w, err := ndjson.NewWriter(c.Response())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		dec := json.NewDecoder(c.Request().Body)
+		for line := 1; ; line++ {
+			var item T
+			err = dec.Decode(&item)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				// The stream can't be recovered once the decoder fails, report it and stop
+				return w.SendJSON("", "", servotp.BulkResult{Line: line, Error: fmt.Sprintf("invalid json: %v", err)})
+			}
+
+			res := fn(c.Request().Context(), &item)
+			res.Line = line
+			err = w.SendJSON("", "", res)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -42,4 +42,38 @@ func RegisterPWDServer(e *echo.Echo, s *servpwd.Server) {
 		}
 		return c.JSON(http.StatusOK, authResp)
 	})
+
+	e.POST("/v1/pwd/import", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var importReq servpwd.ImportReq
+		err = json.Unmarshal(b, &importReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		importResp, err := s.Import(c.Request().Context(), &importReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, importResp)
+	})
+
+	e.POST("/v1/pwd/change", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var changeReq servpwd.ChangeReq
+		err = json.Unmarshal(b, &changeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		changeResp, err := s.Change(c.Request().Context(), &changeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, changeResp)
+	})
 }
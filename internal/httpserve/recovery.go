@@ -0,0 +1,64 @@
+package httpserve
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/twofer/internal/servrecovery"
+	"io"
+	"net/http"
+)
+
+func RegisterRecoveryServer(e *echo.Echo, s *servrecovery.Server) {
+	e.POST("/v1/recovery/enroll", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var enrollReq servrecovery.EnrollReq
+		if len(b) > 0 {
+			err = json.Unmarshal(b, &enrollReq)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, err.Error())
+			}
+		}
+		enrollRes, err := s.Enroll(c.Request().Context(), &enrollReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, enrollRes)
+	})
+
+	e.POST("/v1/recovery/auth", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var authReq servrecovery.AuthReq
+		err = json.Unmarshal(b, &authReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		authRes, err := s.Auth(c.Request().Context(), &authReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, authRes)
+	})
+
+	e.POST("/v1/recovery/upgrade", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var blob servrecovery.Blob
+		err = json.Unmarshal(b, &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		upgraded, err := s.Upgrade(c.Request().Context(), &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, upgraded)
+	})
+}
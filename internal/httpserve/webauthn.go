@@ -0,0 +1,147 @@
+package httpserve
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/twofer/internal/servw6n"
+	"io"
+	"net/http"
+)
+
+func RegisterWebAuthnServer(e *echo.Echo, s *servw6n.Server) {
+	e.POST("/v1/webauthn/enroll/init", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var enrollInitReq servw6n.EnrollInitReq
+		err = json.Unmarshal(b, &enrollInitReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		enrollInitRes, err := s.EnrollInit(c.Request().Context(), &enrollInitReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, enrollInitRes)
+	})
+
+	e.POST("/v1/webauthn/enroll/final", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var finalReq servw6n.FinalReq
+		err = json.Unmarshal(b, &finalReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		finalRes, err := s.EnrollFinal(c.Request().Context(), &finalReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, finalRes)
+	})
+
+	e.POST("/v1/webauthn/auth/init", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var authInitReq servw6n.AuthInitReq
+		err = json.Unmarshal(b, &authInitReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		authInitRes, err := s.AuthInit(c.Request().Context(), &authInitReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, authInitRes)
+	})
+
+	e.POST("/v1/webauthn/auth/final", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var finalReq servw6n.FinalReq
+		err = json.Unmarshal(b, &finalReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		finalRes, err := s.AuthFinal(c.Request().Context(), &finalReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, finalRes)
+	})
+
+	e.POST("/v1/webauthn/upgrade", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var blob servw6n.Blob
+		err = json.Unmarshal(b, &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		upgraded, err := s.Upgrade(c.Request().Context(), &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, upgraded)
+	})
+
+	e.POST("/v1/webauthn/credentials", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var blob servw6n.Blob
+		err = json.Unmarshal(b, &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		credentials, err := s.Credentials(c.Request().Context(), &blob)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, credentials)
+	})
+
+	e.POST("/v1/webauthn/credentials/rename", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var renameReq servw6n.RenameReq
+		err = json.Unmarshal(b, &renameReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		renamed, err := s.RenameCredential(c.Request().Context(), &renameReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, renamed)
+	})
+
+	e.POST("/v1/webauthn/credentials/remove", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err)
+		}
+		var removeReq servw6n.RemoveReq
+		err = json.Unmarshal(b, &removeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		removed, err := s.RemoveCredential(c.Request().Context(), &removeReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, removed)
+	})
+}
@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/modfin/twofer/internal/crypt"
 )
 
+// audience of order tokens, to tell them apart from other tokens signed with the same keys, eg. magic links
+const audience = "twofer:bankid-order"
+
 type claims struct {
 	jwt.RegisteredClaims
 	Payload []byte `json:"payload"`
@@ -62,6 +66,10 @@ func (m *Manager) Parse(orderToken string, endUserIp string) (Payload, error) {
 	if err != nil {
 		return Payload{}, err
 	}
+	// Tokens issued before the audience was added have none, they are accepted as before
+	if len(claims.Audience) > 0 && !slices.Contains(claims.Audience, audience) {
+		return Payload{}, fmt.Errorf("%w: audience is not %s", ErrInvalidToken, audience)
+	}
 	p, err := m.cryptStore.Decrypt(claims.Payload)
 	if err != nil {
 		return Payload{}, fmt.Errorf("failed to decrypt payload: %w", err)
@@ -98,6 +106,7 @@ func (m *Manager) Create(expire time.Duration, payload Payload) (string, error)
 			IssuedAt:  &jwt.NumericDate{Time: t},
 			ExpiresAt: &jwt.NumericDate{Time: t.Add(expire)},
 			Issuer:    "twofer",
+			Audience:  jwt.ClaimStrings{audience},
 		},
 		Payload: p,
 	}
@@ -0,0 +1,99 @@
+package ordertoken
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privOut, pubOut bytes.Buffer
+	_ = pem.Encode(&privOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: priv})
+	_ = pem.Encode(&pubOut, &pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+
+	m, err := NewManager(privOut.String(), pubOut.String(), []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="})
+	if err != nil {
+		t.Fatalf("NewManager(...) returned error: %v", err)
+	}
+	return m
+}
+
+// sign creates a token for aud, a nil aud creates it the way it was done before order tokens had an audience
+func sign(t *testing.T, m *Manager, aud jwt.ClaimStrings, payload Payload) string {
+	t.Helper()
+	b, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.cryptStore.Encrypt(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  &jwt.NumericDate{Time: now},
+			ExpiresAt: &jwt.NumericDate{Time: now.Add(time.Minute)},
+			Issuer:    "twofer",
+			Audience:  aud,
+		},
+		Payload: p,
+	}).SignedString(m.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseAudience(t *testing.T) {
+	m := newTestManager(t)
+	payload := Payload{OrderRef: "order", EndUserIp: "192.0.2.1"}
+
+	token, err := m.Create(time.Minute, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.Parse(token, payload.EndUserIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != payload {
+		t.Fatalf("expected %+v, got %+v", payload, p)
+	}
+
+	// Tokens that were issued without an audience are still in flight after an upgrade
+	p, err = m.Parse(sign(t, m, nil, payload), payload.EndUserIp)
+	if err != nil {
+		t.Fatalf("expected a token without audience to be accepted, got %v", err)
+	}
+	if p != payload {
+		t.Fatalf("expected %+v, got %+v", payload, p)
+	}
+
+	_, err = m.Parse(sign(t, m, jwt.ClaimStrings{"twofer:magic-link"}, payload), payload.EndUserIp)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a token for another audience to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package ordertoken
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/modfin/twofer/internal/crypt"
+)
+
+const issuer = "twofer"
+
+var ErrInvalidToken = errors.New("token is not valid")
+
+// Signer issues ES256 signed tokens with an encrypted payload, the same way as the Manager does for order tokens. Each
+// token is issued for an audience, eg. magic links, and is only valid for that audience, so that tokens signed with the
+// same keys can't be used in place of each other
+type Signer struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  *ecdsa.PublicKey
+	cryptStore crypt.Store
+}
+
+func NewSigner(ec256 string, ec256pub string, encryptionKey []string) (*Signer, error) {
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(ec256))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ECDSA private key: %w", err)
+	}
+	publicKey, err := jwt.ParseECPublicKeyFromPEM([]byte(ec256pub))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ECDSA public key: %w", err)
+	}
+	s, err := crypt.New(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create crypt store: %w", err)
+	}
+	return &Signer{
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		cryptStore: s,
+	}, nil
+}
+
+// Sign encrypts the payload and returns it as a token for the audience, id is set as the jwt id if not empty
+func (s *Signer) Sign(audience string, id string, expire time.Duration, payload any) (string, time.Time, error) {
+	if expire <= 0 {
+		return "", time.Time{}, errors.New("token expire must be positive")
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	p, err := s.cryptStore.Encrypt(b)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+	t := time.Now()
+	c := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			IssuedAt:  &jwt.NumericDate{Time: t},
+			ExpiresAt: &jwt.NumericDate{Time: t.Add(expire)},
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+		},
+		Payload: p,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, c).SignedString(s.privateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, c.ExpiresAt.Time, nil
+}
+
+// Verify validates the token for the audience and decrypts its payload into payload. A token that doesn't validate
+// returns ErrInvalidToken
+func (s *Signer) Verify(token string, audience string, payload any) (jwt.RegisteredClaims, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodES256.Alg() {
+			return nil, fmt.Errorf("unexpected jwt signing method=%v", token.Header["alg"])
+		}
+		return s.publicKey, nil
+	}, jwt.WithIssuer(issuer), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	if err != nil {
+		return jwt.RegisteredClaims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	p, err := s.cryptStore.Decrypt(c.Payload)
+	if err != nil {
+		return jwt.RegisteredClaims{}, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	err = json.Unmarshal(p, payload)
+	if err != nil {
+		return jwt.RegisteredClaims{}, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	return c.RegisteredClaims, nil
+}
@@ -0,0 +1,46 @@
+// Package replay keeps track of single use identifiers, such as token ids or challenges, until they expire
+package replay
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache records that an id has been used. Consume returns false if the id has already been consumed, an id only has
+// to be remembered until it expires since it is rejected by other means after that
+type Cache interface {
+	Consume(id string, expires time.Time) (bool, error)
+}
+
+// Memory is an in-memory Cache, it is only effective within a single instance of twofer. Other backends, eg. shared
+// between replicas, can be used by implementing Cache
+type Memory struct {
+	mu    sync.Mutex
+	ids   map[string]time.Time
+	clean time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{ids: map[string]time.Time{}}
+}
+
+func (m *Memory) Consume(id string, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.clean) > time.Minute {
+		for k, v := range m.ids {
+			if now.After(v) {
+				delete(m.ids, k)
+			}
+		}
+		m.clean = now
+	}
+
+	if e, ok := m.ids[id]; ok && now.Before(e) {
+		return false, nil
+	}
+	m.ids[id] = expires
+	return true, nil
+}
@@ -0,0 +1,28 @@
+package replay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	ok, err := m.Consume("a", time.Now().Add(time.Minute))
+	if err != nil || !ok {
+		t.Fatal("expected first consume to succeed")
+	}
+	ok, err = m.Consume("a", time.Now().Add(time.Minute))
+	if err != nil || ok {
+		t.Fatal("expected second consume to fail")
+	}
+
+	ok, err = m.Consume("b", time.Now().Add(-time.Second))
+	if err != nil || !ok {
+		t.Fatal("expected consume to succeed")
+	}
+	ok, err = m.Consume("b", time.Now().Add(time.Minute))
+	if err != nil || !ok {
+		t.Fatal("expected an expired id to be consumable again")
+	}
+}
@@ -0,0 +1,40 @@
+package servmagic
+
+import "time"
+
+type IssueReq struct {
+	// Subject identifies the user, eg. an email address or user id, it is returned on verify
+	Subject string `json:"subject,omitempty"`
+	// Purpose restricts what the token can be used for, eg. "login" or "verify-email"
+	Purpose string `json:"purpose,omitempty"`
+	// Expire sets the lifetime of the token, defaults to the configured TTL and may not exceed the configured max TTL
+	Expire time.Duration `json:"expire,omitempty"`
+	// EndUserIp if set, the token can only be verified from the same IP
+	EndUserIp string `json:"endUserIp,omitempty"`
+	// BindDevice if true, a device cookie is returned that must be passed on verify. The cookie shall be set in the
+	// browser that requested the link, so that the link only works in the same browser
+	BindDevice bool `json:"bindDevice,omitempty"`
+}
+
+type IssueRes struct {
+	Link         string `json:"link,omitempty"`
+	Token        string `json:"token,omitempty"`
+	DeviceCookie string `json:"deviceCookie,omitempty"`
+	// ExpiresAt is a unix timestamp
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+type VerifyReq struct {
+	Token        string `json:"token,omitempty"`
+	Purpose      string `json:"purpose,omitempty"`
+	EndUserIp    string `json:"endUserIp,omitempty"`
+	DeviceCookie string `json:"deviceCookie,omitempty"`
+}
+
+type VerifyRes struct {
+	Valid   bool   `json:"valid,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	// Reason is set when the token is not valid
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,171 @@
+package servmagic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modfin/twofer/internal/ordertoken"
+	"github.com/modfin/twofer/internal/replay"
+)
+
+type MagicConfig struct {
+	// URLTemplate is a text/template used to render the link, executed with .Token, .Subject and .Purpose
+	URLTemplate string
+	TTL         time.Duration
+	MaxTTL      time.Duration
+}
+
+// audience of magic link tokens, to tell them apart from other tokens signed with the same keys, eg. order tokens
+const audience = "twofer:magic-link"
+
+type Server struct {
+	conf     MagicConfig
+	link     *template.Template
+	signer   *ordertoken.Signer
+	consumed replay.Cache
+}
+
+type payload struct {
+	Subject   string `json:"subject"`
+	Purpose   string `json:"purpose"`
+	EndUserIp string `json:"endUserIp,omitempty"`
+	// Device is the sha256 of the device cookie, if the token is bound to a device
+	Device []byte `json:"device,omitempty"`
+}
+
+// New creates a magic link server, tokens are signed with the ES256 key pair and their payload is encrypted using the
+// encryption keys. If consumed is nil, an in-memory replay.Cache is used
+func New(conf MagicConfig, ec256 string, ec256pub string, encryptionKey []string, consumed replay.Cache) (*Server, error) {
+	if conf.URLTemplate == "" {
+		return nil, errors.New("a url template must be provided")
+	}
+	link, err := template.New("link").Parse(conf.URLTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse url template: %w", err)
+	}
+	signer, err := ordertoken.NewSigner(ec256, ec256pub, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = 15 * time.Minute
+	}
+	if conf.MaxTTL < conf.TTL {
+		conf.MaxTTL = conf.TTL
+	}
+	if consumed == nil {
+		consumed = replay.NewMemory()
+	}
+	return &Server{
+		conf:     conf,
+		link:     link,
+		signer:   signer,
+		consumed: consumed,
+	}, nil
+}
+
+func (s *Server) Issue(_ context.Context, req *IssueReq) (*IssueRes, error) {
+	if req.Subject == "" {
+		return nil, errors.New("a subject must be provided")
+	}
+	if req.Purpose == "" {
+		return nil, errors.New("a purpose must be provided")
+	}
+	expire := req.Expire
+	if expire <= 0 {
+		expire = s.conf.TTL
+	}
+	if expire > s.conf.MaxTTL {
+		return nil, fmt.Errorf("expire may not exceed %v", s.conf.MaxTTL)
+	}
+
+	res := &IssueRes{}
+	p := payload{
+		Subject:   req.Subject,
+		Purpose:   req.Purpose,
+		EndUserIp: req.EndUserIp,
+	}
+	if req.BindDevice {
+		cookie := make([]byte, 32)
+		_, err := rand.Read(cookie)
+		if err != nil {
+			return nil, err
+		}
+		res.DeviceCookie = base64.RawURLEncoding.EncodeToString(cookie)
+		d := sha256.Sum256([]byte(res.DeviceCookie))
+		p.Device = d[:]
+	}
+
+	token, expiresAt, err := s.signer.Sign(audience, uuid.NewString(), expire, p)
+	if err != nil {
+		return nil, err
+	}
+	res.Token = token
+	res.ExpiresAt = expiresAt.Unix()
+
+	var link strings.Builder
+	err = s.link.Execute(&link, map[string]string{
+		"Token":   res.Token,
+		"Subject": req.Subject,
+		"Purpose": req.Purpose,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render link: %w", err)
+	}
+	res.Link = link.String()
+
+	return res, nil
+}
+
+// Verify validates the token and its bindings, and consumes it so that it can't be used again
+func (s *Server) Verify(_ context.Context, req *VerifyReq) (*VerifyRes, error) {
+	if req.Purpose == "" {
+		return nil, errors.New("a purpose must be provided")
+	}
+
+	var p payload
+	c, err := s.signer.Verify(req.Token, audience, &p)
+	if errors.Is(err, ordertoken.ErrInvalidToken) {
+		return &VerifyRes{Reason: "token is not valid"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Purpose != req.Purpose {
+		return &VerifyRes{Reason: "token purpose mismatch"}, nil
+	}
+	if p.EndUserIp != "" && p.EndUserIp != req.EndUserIp {
+		return &VerifyRes{Reason: "token ip mismatch"}, nil
+	}
+	if len(p.Device) > 0 {
+		d := sha256.Sum256([]byte(req.DeviceCookie))
+		if subtle.ConstantTimeCompare(d[:], p.Device) != 1 {
+			return &VerifyRes{Reason: "token device mismatch"}, nil
+		}
+	}
+
+	// Consuming the token last, so that a failed binding doesn't burn the token
+	ok, err := s.consumed.Consume(c.ID, c.ExpiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &VerifyRes{Reason: "token has already been used"}, nil
+	}
+
+	return &VerifyRes{
+		Valid:   true,
+		Subject: p.Subject,
+		Purpose: p.Purpose,
+	}, nil
+}
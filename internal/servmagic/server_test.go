@@ -0,0 +1,171 @@
+package servmagic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modfin/twofer/internal/ordertoken"
+)
+
+const testEncryptionKey = "1:aes:uTdWcGl+cOnIgHoGnuBF3w=="
+
+func newTestKeys(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privOut, pubOut bytes.Buffer
+	_ = pem.Encode(&privOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: priv})
+	_ = pem.Encode(&pubOut, &pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+	return privOut.String(), pubOut.String()
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	priv, pub := newTestKeys(t)
+	s, err := New(MagicConfig{
+		URLTemplate: "https://example.com/login?token={{.Token | urlquery}}",
+		TTL:         time.Minute,
+		MaxTTL:      time.Hour,
+	}, priv, pub, []string{testEncryptionKey}, nil)
+	if err != nil {
+		t.Fatalf("New(...) returned error: %v", err)
+	}
+	return s
+}
+
+func TestIssueVerify(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	res, err := s.Issue(ctx, &IssueReq{Subject: "john@example.com", Purpose: "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.Link, "https://example.com/login?token=") || !strings.Contains(res.Link, res.Token) {
+		t.Fatalf("unexpected link %s", res.Link)
+	}
+
+	v, err := s.Verify(ctx, &VerifyReq{Token: res.Token, Purpose: "verify-email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid {
+		t.Fatal("expected token to be rejected for another purpose")
+	}
+
+	v, err = s.Verify(ctx, &VerifyReq{Token: res.Token, Purpose: "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Subject != "john@example.com" {
+		t.Fatalf("expected token to be valid, got %+v", v)
+	}
+
+	v, err = s.Verify(ctx, &VerifyReq{Token: res.Token, Purpose: "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid {
+		t.Fatal("expected token to only be usable once")
+	}
+
+	_, err = s.Issue(ctx, &IssueReq{Subject: "john@example.com", Purpose: "login", Expire: 2 * time.Hour})
+	if err == nil {
+		t.Fatal("expected expire above max ttl to be rejected")
+	}
+}
+
+func TestBindings(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	res, err := s.Issue(ctx, &IssueReq{Subject: "john", Purpose: "login", EndUserIp: "10.0.0.1", BindDevice: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeviceCookie == "" {
+		t.Fatal("expected a device cookie")
+	}
+
+	tests := []struct {
+		name  string
+		req   VerifyReq
+		valid bool
+	}{
+		{name: "wrong ip", req: VerifyReq{Token: res.Token, Purpose: "login", EndUserIp: "10.0.0.2", DeviceCookie: res.DeviceCookie}},
+		{name: "no cookie", req: VerifyReq{Token: res.Token, Purpose: "login", EndUserIp: "10.0.0.1"}},
+		{name: "wrong cookie", req: VerifyReq{Token: res.Token, Purpose: "login", EndUserIp: "10.0.0.1", DeviceCookie: "abc"}},
+		{name: "bound", req: VerifyReq{Token: res.Token, Purpose: "login", EndUserIp: "10.0.0.1", DeviceCookie: res.DeviceCookie}, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := s.Verify(ctx, &tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Valid != tt.valid {
+				t.Fatalf("expected valid=%v, got %+v", tt.valid, v)
+			}
+		})
+	}
+}
+
+func TestOtherTokens(t *testing.T) {
+	ctx := context.Background()
+	priv, pub := newTestKeys(t)
+	s, err := New(MagicConfig{URLTemplate: "https://example.com/login?token={{.Token}}"}, priv, pub, []string{testEncryptionKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Verify(ctx, &VerifyReq{Token: "token"})
+	if err == nil {
+		t.Fatal("expected a purpose to be required")
+	}
+
+	// An order token signed with the same keys is not a magic link
+	otm, err := ordertoken.NewManager(priv, pub, []string{testEncryptionKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := otm.Create(time.Minute, ordertoken.Payload{OrderRef: "order"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Verify(ctx, &VerifyReq{Token: order, Purpose: "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid {
+		t.Fatal("expected an order token to be rejected")
+	}
+
+	// Nor is a magic link an order token
+	res, err := s.Issue(ctx, &IssueReq{Subject: "john@example.com", Purpose: "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = otm.Parse(res.Token, "")
+	if !errors.Is(err, ordertoken.ErrInvalidToken) {
+		t.Fatalf("expected a magic link to be rejected as order token, got %v", err)
+	}
+}
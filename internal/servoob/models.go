@@ -0,0 +1,108 @@
+package servoob
+
+type ChallengeReq struct {
+	// Channel is the delivery channel, eg. "email" or "sms"
+	Channel     string `json:"channel,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+func (m *ChallengeReq) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *ChallengeReq) GetDestination() string {
+	if m != nil {
+		return m.Destination
+	}
+	return ""
+}
+
+type ChallengeRes struct {
+	// Challenge is the sealed challenge that shall be passed to verify, it should not be exposed to the user
+	Challenge string `json:"challenge,omitempty"`
+	// ExpiresAt is a unix timestamp after which the code can no longer be verified
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+func (m *ChallengeRes) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+func (m *ChallengeRes) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
+type VerifyReq struct {
+	Code      string `json:"code,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+}
+
+func (m *VerifyReq) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *VerifyReq) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+type VerifyRes struct {
+	Valid bool `json:"valid,omitempty"`
+	// Channel and Destination that the verified code was delivered to
+	Channel     string `json:"channel,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Remaining is the number of attempts left on the challenge
+	Remaining uint32 `json:"remaining"`
+	// Challenge is the challenge with the attempt counter updated, it shall be used for further attempts. It is
+	// not set once the code has been verified or no attempts remains
+	Challenge string `json:"challenge,omitempty"`
+}
+
+func (m *VerifyRes) GetValid() bool {
+	if m != nil {
+		return m.Valid
+	}
+	return false
+}
+
+func (m *VerifyRes) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *VerifyRes) GetDestination() string {
+	if m != nil {
+		return m.Destination
+	}
+	return ""
+}
+
+func (m *VerifyRes) GetRemaining() uint32 {
+	if m != nil {
+		return m.Remaining
+	}
+	return 0
+}
+
+func (m *VerifyRes) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package servoob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Message is what a Sender delivers to the user
+type Message struct {
+	Channel     string    `json:"channel"`
+	Destination string    `json:"destination"`
+	Code        string    `json:"code"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Sender delivers a one-time code to a destination, eg. an email address or a phone number
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Subject  string
+	// Body is a text/template that is executed with the Message
+	Body string
+}
+
+type SMTPSender struct {
+	conf SMTPConfig
+	body *template.Template
+}
+
+func NewSMTPSender(conf SMTPConfig) (*SMTPSender, error) {
+	if conf.Host == "" || conf.From == "" {
+		return nil, errors.New("smtp host and from address must be provided")
+	}
+	if conf.Port == 0 {
+		conf.Port = 587
+	}
+	if conf.Subject == "" {
+		conf.Subject = "Your verification code"
+	}
+	if conf.Body == "" {
+		conf.Body = "Your verification code is {{.Code}}\r\n\r\nIt is valid until {{.ExpiresAt.Format \"15:04 MST\"}}.\r\n"
+	}
+	body, err := template.New("body").Parse(conf.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse smtp body template: %w", err)
+	}
+	return &SMTPSender{conf: conf, body: body}, nil
+}
+
+func (s *SMTPSender) Send(_ context.Context, msg Message) error {
+	if strings.ContainsAny(msg.Destination, "\r\n") {
+		return errors.New("destination is not a valid email address")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.Destination)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", s.conf.Subject)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	err := s.body.Execute(&buf, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.conf.Username != "" {
+		auth = smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)
+	}
+	addr := net.JoinHostPort(s.conf.Host, fmt.Sprint(s.conf.Port))
+	return smtp.SendMail(addr, auth, s.conf.From, []string{msg.Destination}, buf.Bytes())
+}
+
+type WebhookConfig struct {
+	URL string
+	// Secret, if set, is used to sign the request body with HMAC-SHA256, the hex encoded signature is sent in the
+	// X-Twofer-Signature header
+	Secret  string
+	Timeout time.Duration
+}
+
+// WebhookSender posts the Message as JSON to a URL, eg. an internal service that relays it as an SMS
+type WebhookSender struct {
+	conf   WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookSender(conf WebhookConfig) (*WebhookSender, error) {
+	if conf.URL == "" {
+		return nil, errors.New("webhook url must be provided")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &WebhookSender{conf: conf, client: &http.Client{Timeout: conf.Timeout}}, nil
+}
+
+func (w *WebhookSender) Send(ctx context.Context, msg Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.conf.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.conf.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.conf.Secret))
+		mac.Write(b)
+		req.Header.Set("X-Twofer-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package servoob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/modfin/twofer/internal/crypt"
+	"github.com/modfin/twofer/internal/ratelimit"
+	"github.com/modfin/twofer/internal/replay"
+)
+
+var ErrChallengeUsed = errors.New("challenge has already been used")
+
+type OOBConfig struct {
+	// CodeLength is the number of digits in a code
+	CodeLength int
+	// TTL is for how long a code is valid
+	TTL time.Duration
+	// MaxAttempts is how many verification attempts that can be made on a single challenge
+	MaxAttempts uint32
+	// RateLimit is how many challenges that can be sent to the same destination, and how many verification attempts
+	// that can be made on the same challenge, a minute
+	RateLimit uint
+}
+
+type Server struct {
+	store       crypt.Store
+	conf        OOBConfig
+	senders     map[string]Sender
+	ratelimiter *ratelimit.Ratelimiter
+	consumed    replay.Cache
+}
+
+// New creates an out-of-band otp server, senders maps a channel, eg. "email" or "sms", to the Sender used to deliver
+// codes on it. Encryption keys are required since the challenge holds the hash of a short code. If consumed is nil, an
+// in-memory replay.Cache is used
+func New(conf OOBConfig, keys []string, senders map[string]Sender, consumed replay.Cache) (*Server, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("an encryption key must be provided")
+	}
+	store, err := crypt.New(keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(senders) == 0 {
+		return nil, errors.New("at least one sender must be provided")
+	}
+
+	if consumed == nil {
+		consumed = replay.NewMemory()
+	}
+
+	s := &Server{store: store, conf: conf, senders: senders, consumed: consumed}
+	if s.conf.CodeLength < 6 {
+		s.conf.CodeLength = 6
+	}
+	if s.conf.TTL <= 0 {
+		s.conf.TTL = 5 * time.Minute
+	}
+	if s.conf.MaxAttempts == 0 {
+		s.conf.MaxAttempts = 5
+	}
+	if s.conf.RateLimit == 0 {
+		s.conf.RateLimit = 3
+	}
+	s.ratelimiter = ratelimit.New(s.conf.RateLimit)
+	return s, nil
+}
+
+type challenge struct {
+	Channel     string `json:"channel"`
+	Destination string `json:"destination"`
+	Salt        string `json:"salt"`
+	Digest      string `json:"digest"`
+	ExpiresAt   int64  `json:"expiresAt"`
+	Attempts    uint32 `json:"attempts"`
+}
+
+func (s *Server) open(blob string) (challenge, error) {
+	var c challenge
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return c, err
+	}
+	b, err = s.store.Decrypt(b)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+func (s *Server) seal(c challenge) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	b, err = s.store.Encrypt(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Challenge generates a code, delivers it to the destination and returns the sealed challenge used to verify it
+func (s *Server) Challenge(ctx context.Context, req *ChallengeReq) (*ChallengeRes, error) {
+	sender, ok := s.senders[req.Channel]
+	if !ok {
+		return nil, fmt.Errorf("channel %q is not supported", req.Channel)
+	}
+	if req.Destination == "" {
+		return nil, errors.New("a destination must be provided")
+	}
+
+	err := s.ratelimiter.Hit(req.Channel + ":" + req.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := generateCode(s.conf.CodeLength)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.conf.TTL)
+	c := challenge{
+		Channel:     req.Channel,
+		Destination: req.Destination,
+		Salt:        base64.StdEncoding.EncodeToString(salt),
+		ExpiresAt:   expiresAt.Unix(),
+	}
+	c.Digest = digest(c.Salt, code)
+
+	blob, err := s.seal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sender.Send(ctx, Message{
+		Channel:     req.Channel,
+		Destination: req.Destination,
+		Code:        code,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not deliver code: %w", err)
+	}
+
+	return &ChallengeRes{Challenge: blob, ExpiresAt: c.ExpiresAt}, nil
+}
+
+// Verify checks the code against the challenge. On a failed attempt the challenge with an updated attempt counter is
+// returned, which shall be used for further attempts. Each attempt is consumed, so that an earlier challenge can't be
+// replayed to reset the counter, or to verify the code again once it has been verified
+func (s *Server) Verify(_ context.Context, req *VerifyReq) (*VerifyRes, error) {
+	c, err := s.open(req.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > c.ExpiresAt {
+		return nil, errors.New("challenge has expired")
+	}
+	if c.Attempts >= s.conf.MaxAttempts {
+		return nil, errors.New("no attempts remaining for challenge")
+	}
+
+	err = s.ratelimiter.Hit("verify:" + c.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Attempts++
+	ok, err := s.consumed.Consume(fmt.Sprintf("%s:%d", c.Salt, c.Attempts), time.Unix(c.ExpiresAt, 0).Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrChallengeUsed
+	}
+
+	res := &VerifyRes{Channel: c.Channel, Destination: c.Destination}
+	res.Remaining = s.conf.MaxAttempts - c.Attempts
+	if hmac.Equal([]byte(digest(c.Salt, req.Code)), []byte(c.Digest)) {
+		res.Valid = true
+		res.Remaining = 0
+		return res, nil
+	}
+
+	if res.Remaining > 0 {
+		res.Challenge, err = s.seal(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func generateCode(length int) (string, error) {
+	code := make([]byte, length)
+	ten := big.NewInt(10)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+func digest(salt string, code string) string {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(code))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,125 @@
+package servoob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	last Message
+}
+
+func (f *fakeSender) Send(_ context.Context, msg Message) error {
+	f.last = msg
+	return nil
+}
+
+func newTestServer(t *testing.T, conf OOBConfig) (*Server, *fakeSender) {
+	t.Helper()
+	sender := &fakeSender{}
+	s, err := New(conf, []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="}, map[string]Sender{"sms": sender}, nil)
+	if err != nil {
+		t.Fatalf("New(...) returned error: %v", err)
+	}
+	return s, sender
+}
+
+func newChallenge(t *testing.T, s *Server) string {
+	t.Helper()
+	res, err := s.Challenge(context.Background(), &ChallengeReq{Channel: "sms", Destination: "+46700000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Challenge
+}
+
+func TestVerifyAttemptsExhausted(t *testing.T) {
+	s, sender := newTestServer(t, OOBConfig{MaxAttempts: 2, RateLimit: 100})
+	ctx := context.Background()
+	blob := newChallenge(t, s)
+
+	res, err := s.Verify(ctx, &VerifyReq{Code: "wrong", Challenge: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Remaining != 1 || res.Challenge == "" {
+		t.Fatalf("expected a failed attempt with 1 remaining, got %+v", res)
+	}
+
+	res, err = s.Verify(ctx, &VerifyReq{Code: "wrong", Challenge: res.Challenge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Remaining != 0 || res.Challenge != "" {
+		t.Fatalf("expected no attempts and no challenge to remain, got %+v", res)
+	}
+
+	// A challenge that has used all attempts is rejected, even with the correct code
+	c, err := s.open(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Attempts = 2
+	blob, err = s.seal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Verify(ctx, &VerifyReq{Code: sender.last.Code, Challenge: blob})
+	if err == nil {
+		t.Fatal("expected a challenge without remaining attempts to be rejected")
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	s, sender := newTestServer(t, OOBConfig{RateLimit: 100})
+	ctx := context.Background()
+	blob := newChallenge(t, s)
+
+	res, err := s.Verify(ctx, &VerifyReq{Code: "wrong", Challenge: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the original challenge to reset the attempt counter is rejected
+	_, err = s.Verify(ctx, &VerifyReq{Code: sender.last.Code, Challenge: blob})
+	if !errors.Is(err, ErrChallengeUsed) {
+		t.Fatalf("expected %v when replaying an old challenge, got %v", ErrChallengeUsed, err)
+	}
+
+	next := res.Challenge
+	res, err = s.Verify(ctx, &VerifyReq{Code: sender.last.Code, Challenge: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected the code to be valid with the latest challenge")
+	}
+
+	// A verified code can't be verified again
+	_, err = s.Verify(ctx, &VerifyReq{Code: sender.last.Code, Challenge: next})
+	if !errors.Is(err, ErrChallengeUsed) {
+		t.Fatalf("expected %v when replaying a verified challenge, got %v", ErrChallengeUsed, err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	s, sender := newTestServer(t, OOBConfig{RateLimit: 100})
+	ctx := context.Background()
+
+	c, err := s.open(newChallenge(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	blob, err := s.seal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Verify(ctx, &VerifyReq{Code: sender.last.Code, Challenge: blob})
+	if err == nil {
+		t.Fatal("expected an expired challenge to be rejected")
+	}
+}
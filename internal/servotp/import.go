@@ -0,0 +1,170 @@
+package servotp
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pquerna/otp"
+	"golang.org/x/net/context"
+)
+
+// minSecretSize is the smallest secret, in bytes, that is accepted on import. RFC 4226 recommends 20 bytes but many
+// authenticators have been provisioned with 10 byte (16 base32 characters) secrets
+const minSecretSize = 10
+
+// Import validates an existing secret, either as an otpauth:// uri or as a raw base32 secret with parameters, and
+// returns it sealed in a userBlob in the same way as Enroll
+func (s *Server) Import(ctx context.Context, im *Import) (*EnrollmentResponse, error) {
+	if im.Uri != "" {
+		var err error
+		im, err = parseImportURI(im.Uri)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secret := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(im.Secret))
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, errors.New("secret is expected to be base32 encoded")
+	}
+	if len(raw) < minSecretSize {
+		return nil, fmt.Errorf("secret must be at least %d bytes", minSecretSize)
+	}
+
+	var digits otp.Digits
+	switch im.Digits {
+	case Digits_SIX:
+		digits = otp.DigitsSix
+	case Digits_EIGHT:
+		digits = otp.DigitsEight
+	default:
+		return nil, errors.New("digits must be six or eight")
+	}
+
+	if _, ok := Alg_name[int32(im.Alg)]; !ok {
+		return nil, errors.New("alg must be SHA_1, SHA_256 or SHA_512")
+	}
+
+	err = s.allowed(im.Alg, im.Mode, im.Digits, im.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	if im.Account == "" {
+		return nil, errors.New("an account must be provided")
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	if im.Issuer != "" {
+		q.Set("issuer", im.Issuer)
+	}
+	q.Set("algorithm", otp.Algorithm(im.Alg).String())
+	q.Set("digits", digits.String())
+
+	var o wrapper
+	u := url.URL{Scheme: "otpauth"}
+	switch im.Mode {
+	case Mode_TIME:
+		period := im.Period
+		if period == 0 {
+			period = 30
+		}
+		q.Set("period", strconv.FormatUint(uint64(period), 10))
+		u.Host = "totp"
+	case Mode_COUNTER:
+		u.Host = "hotp"
+		o.Counter = im.Counter
+	default:
+		return nil, errors.New("mode must be time or counter")
+	}
+	u.RawQuery = q.Encode()
+	u.Path = "/" + im.Account
+	if im.Issuer != "" {
+		u.Path = "/" + im.Issuer + ":" + im.Account
+	}
+	o.URI = u.String()
+
+	userBlob, err := s.seal(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnrollmentResponse{
+		Uri:      o.URI,
+		UserBlob: userBlob,
+	}, nil
+}
+
+func parseImportURI(uri string) (*Import, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" {
+		return nil, errors.New("uri scheme must be otpauth")
+	}
+	q := u.Query()
+
+	im := &Import{Secret: q.Get("secret")}
+
+	switch u.Host {
+	case "totp":
+		im.Mode = Mode_TIME
+	case "hotp":
+		im.Mode = Mode_COUNTER
+		if c := q.Get("counter"); c != "" {
+			im.Counter, err = strconv.ParseUint(c, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("counter is not valid: %w", err)
+			}
+		}
+	default:
+		return nil, errors.New("otp scheme is not valid " + u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	im.Account = label
+	if i := strings.Index(label, ":"); i >= 0 {
+		im.Issuer = strings.TrimSpace(label[:i])
+		im.Account = strings.TrimSpace(label[i+1:])
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		im.Issuer = issuer
+	}
+
+	switch strings.ToUpper(q.Get("algorithm")) {
+	case "", "SHA1":
+		im.Alg = Alg_SHA_1
+	case "SHA256":
+		im.Alg = Alg_SHA_256
+	case "SHA512":
+		im.Alg = Alg_SHA_512
+	default:
+		return nil, errors.New("algorithm is not supported " + q.Get("algorithm"))
+	}
+
+	switch q.Get("digits") {
+	case "", "6":
+		im.Digits = Digits_SIX
+	case "8":
+		im.Digits = Digits_EIGHT
+	default:
+		return nil, errors.New("digits is not supported " + q.Get("digits"))
+	}
+
+	if p := q.Get("period"); p != "" {
+		period, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || period == 0 {
+			return nil, errors.New("period is not valid " + p)
+		}
+		im.Period = uint32(period)
+	}
+
+	return im, nil
+}
@@ -0,0 +1,82 @@
+package servotp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+const importSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func TestImportURI(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100})
+	ctx := context.Background()
+
+	en, err := s.Import(ctx, &Import{Uri: "otpauth://totp/ACME%20Co:john@example.com?secret=" + importSecret + "&issuer=ACME+Co&algorithm=SHA1&digits=6&period=30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.GenerateCode(importSecret, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp of imported secret to be valid")
+	}
+}
+
+func TestImportSecret(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewCounter: 1})
+	ctx := context.Background()
+
+	en, err := s.Import(ctx, &Import{Secret: "jbsw y3dp ehpk 3pxp jbsw y3dp ehpk 3pxp", Account: "john", Mode: Mode_COUNTER, Counter: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := hotp.GenerateCode(importSecret, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp of imported secret to be valid")
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	s := newTestServer(t, OTPConfig{})
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		im   Import
+	}{
+		{name: "wrong scheme", im: Import{Uri: "https://totp/john?secret=" + importSecret}},
+		{name: "unknown type", im: Import{Uri: "otpauth://motp/john?secret=" + importSecret}},
+		{name: "unsupported digits", im: Import{Uri: "otpauth://totp/john?digits=7&secret=" + importSecret}},
+		{name: "unsupported algorithm", im: Import{Uri: "otpauth://totp/john?algorithm=MD5&secret=" + importSecret}},
+		{name: "not base32", im: Import{Secret: "not-base-32!", Account: "john"}},
+		{name: "short secret", im: Import{Secret: "JBSWY3DP", Account: "john"}},
+		{name: "no account", im: Import{Secret: importSecret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Import(ctx, &tt.im)
+			if err == nil {
+				t.Fatal("expected import to fail")
+			}
+		})
+	}
+}
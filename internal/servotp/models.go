@@ -1,5 +1,7 @@
 package servotp
 
+import "github.com/modfin/twofer/internal/servqr"
+
 type Alg int32
 
 const (
@@ -110,6 +112,30 @@ func (m *Enrollment) GetSecretSize() uint32 {
 	return 0
 }
 
+// Import holds an existing secret, either as an otpauth:// Uri or as a base32 Secret with the remaining fields as
+// parameters. If Uri is set, all other fields are ignored
+type Import struct {
+	Ref     string `json:"ref,omitempty"`
+	Uri     string `json:"uri,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	Account string `json:"account,omitempty"`
+	Alg     Alg    `json:"alg,omitempty"`
+	Mode    Mode   `json:"mode,omitempty"`
+	Digits  Digits `json:"digits,omitempty"`
+	Period  uint32 `json:"period,omitempty"`
+	Counter uint64 `json:"counter,omitempty"`
+}
+
+// BulkResult is returned for each line in a bulk import or upgrade, Ref is copied from the request line to correlate
+// the result. Either UserBlob or Error is set
+type BulkResult struct {
+	Ref      string `json:"ref,omitempty"`
+	Line     int    `json:"line"`
+	UserBlob string `json:"userBlob,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
 type EnrollmentResponse struct {
 	Uri      string `json:"uri,omitempty"`
 	UserBlob string `json:"userBlob,omitempty"`
@@ -129,9 +155,58 @@ func (m *EnrollmentResponse) GetUserBlob() string {
 	return ""
 }
 
+// Policy optionally overrides how an otp is verified. The skews may not exceed the upper bounds configured on the
+// server, and the otp is rejected if its algorithm or digits doesn't satisfy the policy
+type Policy struct {
+	SkewCounter *uint32 `json:"skewCounter,omitempty"`
+	SkewTime    *uint32 `json:"skewTime,omitempty"`
+	// ReplayProtection can only enable replay protection, a request can't disable it when it's enabled on the server
+	ReplayProtection *bool `json:"replayProtection,omitempty"`
+	Algs             []Alg `json:"algs,omitempty"`
+	// MinDigits is only enforced for EIGHT, SIX is the zero value and can't be told apart from not being set, which is
+	// fine since no fewer digits can be enrolled
+	MinDigits Digits `json:"minDigits,omitempty"`
+}
+
+func (m *Policy) GetSkewCounter() *uint32 {
+	if m != nil {
+		return m.SkewCounter
+	}
+	return nil
+}
+
+func (m *Policy) GetSkewTime() *uint32 {
+	if m != nil {
+		return m.SkewTime
+	}
+	return nil
+}
+
+func (m *Policy) GetReplayProtection() *bool {
+	if m != nil {
+		return m.ReplayProtection
+	}
+	return nil
+}
+
+func (m *Policy) GetAlgs() []Alg {
+	if m != nil {
+		return m.Algs
+	}
+	return nil
+}
+
+func (m *Policy) GetMinDigits() Digits {
+	if m != nil {
+		return m.MinDigits
+	}
+	return Digits_SIX
+}
+
 type Credentials struct {
-	Otp      string `json:"otp,omitempty"`
-	UserBlob string `json:"userBlob,omitempty"`
+	Otp      string  `json:"otp,omitempty"`
+	UserBlob string  `json:"userBlob,omitempty"`
+	Policy   *Policy `json:"policy,omitempty"`
 }
 
 func (m *Credentials) GetOtp() string {
@@ -148,9 +223,46 @@ func (m *Credentials) GetUserBlob() string {
 	return ""
 }
 
+func (m *Credentials) GetPolicy() *Policy {
+	if m != nil {
+		return m.Policy
+	}
+	return nil
+}
+
+type Resync struct {
+	// Otp and NextOtp shall be two consecutive otps generated by the users token or authenticator
+	Otp      string `json:"otp,omitempty"`
+	NextOtp  string `json:"nextOtp,omitempty"`
+	UserBlob string `json:"userBlob,omitempty"`
+}
+
+func (m *Resync) GetOtp() string {
+	if m != nil {
+		return m.Otp
+	}
+	return ""
+}
+
+func (m *Resync) GetNextOtp() string {
+	if m != nil {
+		return m.NextOtp
+	}
+	return ""
+}
+
+func (m *Resync) GetUserBlob() string {
+	if m != nil {
+		return m.UserBlob
+	}
+	return ""
+}
+
 type AuthResponse struct {
 	Valid    bool   `json:"valid,omitempty"`
 	UserBlob string `json:"userBlob,omitempty"`
+	// LockedUntil is set, as a unix timestamp, when too many failed attempts have been made and the user is locked out
+	LockedUntil int64 `json:"lockedUntil,omitempty"`
 }
 
 func (m *AuthResponse) GetValid() bool {
@@ -167,7 +279,33 @@ func (m *AuthResponse) GetUserBlob() string {
 	return ""
 }
 
+func (m *AuthResponse) GetLockedUntil() int64 {
+	if m != nil {
+		return m.LockedUntil
+	}
+	return 0
+}
+
+type QRReq struct {
+	UserBlob      string               `json:"userBlob,omitempty"`
+	Size          int32                `json:"size,omitempty"`
+	RecoveryLevel servqr.Data_Recovery `json:"recoveryLevel,omitempty"`
+	Format        servqr.Format        `json:"format,omitempty"`
+	// IncludeSecret if true, the base32 secret is returned for manual entry, in groups of four characters
+	IncludeSecret bool `json:"includeSecret,omitempty"`
+}
+
+type QRRes struct {
+	ContentType string `json:"contentType,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	Secret      string `json:"secret,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	Account     string `json:"account,omitempty"`
+}
+
 type Blob struct {
+	// Ref is optional, and only used to correlate results in bulk upgrades
+	Ref      string `json:"ref,omitempty"`
 	UserBlob string `json:"userBlob,omitempty"`
 }
 
@@ -0,0 +1,79 @@
+package servotp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// policy is the resolved policy used to verify an otp, combining the server defaults with the optional Policy in the
+// request
+type policy struct {
+	skewCounter      uint
+	skewTime         uint
+	replayProtection bool
+	algs             []Alg
+	minDigits        Digits
+}
+
+func (s *Server) policy(p *Policy) (policy, error) {
+	res := policy{
+		skewCounter:      s.conf.SkewCounter,
+		skewTime:         s.conf.SkewTime,
+		replayProtection: s.conf.ReplayProtection,
+	}
+	if p == nil {
+		return res, nil
+	}
+
+	if p.SkewCounter != nil {
+		if uint(*p.SkewCounter) > s.conf.MaxSkewCounter {
+			return res, fmt.Errorf("policy skew counter may not exceed %d", s.conf.MaxSkewCounter)
+		}
+		res.skewCounter = uint(*p.SkewCounter)
+	}
+	if p.SkewTime != nil {
+		if uint(*p.SkewTime) > s.conf.MaxSkewTime {
+			return res, fmt.Errorf("policy skew time may not exceed %d", s.conf.MaxSkewTime)
+		}
+		res.skewTime = uint(*p.SkewTime)
+	}
+	// A request may only make the verification stricter, replay protection enabled on the server stays enabled
+	if p.ReplayProtection != nil && *p.ReplayProtection {
+		res.replayProtection = true
+	}
+	res.algs = p.Algs
+	res.minDigits = p.MinDigits
+	return res, nil
+}
+
+// check verifies that the parameters of an enrolled otp satisfies the policy
+func (p policy) check(op params) error {
+	if len(p.algs) > 0 && !slices.Contains(p.algs, Alg(op.alg)) {
+		return errors.New("otp algorithm is not allowed by policy")
+	}
+	if p.minDigits == Digits_EIGHT && op.digits < 8 {
+		return errors.New("otp digits is below the minimum of policy")
+	}
+	return nil
+}
+
+// allowed verifies that an enrollment is within the configured sets of algorithms, digits and periods
+func (s *Server) allowed(alg Alg, mode Mode, digits Digits, period uint32) error {
+	if len(s.conf.AllowedAlgs) > 0 && !slices.Contains(s.conf.AllowedAlgs, alg) {
+		return fmt.Errorf("alg %s is not allowed", Alg_name[int32(alg)])
+	}
+	if len(s.conf.AllowedDigits) > 0 && !slices.Contains(s.conf.AllowedDigits, digits) {
+		return fmt.Errorf("digits %s is not allowed", Digits_name[int32(digits)])
+	}
+	if mode != Mode_TIME {
+		return nil
+	}
+	if period == 0 {
+		period = 30
+	}
+	if len(s.conf.AllowedPeriods) > 0 && !slices.Contains(s.conf.AllowedPeriods, period) {
+		return fmt.Errorf("period %d is not allowed", period)
+	}
+	return nil
+}
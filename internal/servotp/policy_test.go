@@ -0,0 +1,129 @@
+package servotp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestPolicy(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewTime: 1, MaxSkewTime: 2})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "policy", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(secretOf(t, en.Uri), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skew := uint32(3)
+	_, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob, Policy: &Policy{SkewTime: &skew}})
+	if err == nil {
+		t.Fatal("expected a skew above the max to be rejected")
+	}
+
+	_, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob, Policy: &Policy{Algs: []Alg{Alg_SHA_512}}})
+	if err == nil {
+		t.Fatal("expected an algorithm outside of the policy to be rejected")
+	}
+
+	_, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob, Policy: &Policy{MinDigits: Digits_EIGHT}})
+	if err == nil {
+		t.Fatal("expected digits below the policy to be rejected")
+	}
+
+	replay := true
+	pol := &Policy{ReplayProtection: &replay}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob, Policy: pol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid")
+	}
+
+	replayed, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: res.UserBlob, Policy: pol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Valid {
+		t.Fatal("expected replayed otp to be rejected")
+	}
+
+	// Without replay protection the otp is accepted again
+	res, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid without replay protection")
+	}
+}
+
+func TestPolicyReplayProtection(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewTime: 1, ReplayProtection: true})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "policy", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(secretOf(t, en.Uri), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid")
+	}
+
+	// Replay protection enabled on the server can't be disabled by a request
+	replay := false
+	replayed, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: res.UserBlob, Policy: &Policy{ReplayProtection: &replay}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Valid {
+		t.Fatal("expected replayed otp to be rejected")
+	}
+}
+
+func TestEnrollAllowed(t *testing.T) {
+	s := newTestServer(t, OTPConfig{
+		AllowedAlgs:    []Alg{Alg_SHA_256},
+		AllowedDigits:  []Digits{Digits_EIGHT},
+		AllowedPeriods: []uint32{60},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		en    Enrollment
+		valid bool
+	}{
+		{name: "allowed", en: Enrollment{Issuer: "twofer", Account: "a", Alg: Alg_SHA_256, Digits: Digits_EIGHT, Period: 60}, valid: true},
+		{name: "alg", en: Enrollment{Issuer: "twofer", Account: "a", Alg: Alg_SHA_1, Digits: Digits_EIGHT, Period: 60}},
+		{name: "digits", en: Enrollment{Issuer: "twofer", Account: "a", Alg: Alg_SHA_256, Digits: Digits_SIX, Period: 60}},
+		{name: "default period", en: Enrollment{Issuer: "twofer", Account: "a", Alg: Alg_SHA_256, Digits: Digits_EIGHT}},
+		{name: "counter ignores period", en: Enrollment{Issuer: "twofer", Account: "a", Alg: Alg_SHA_256, Digits: Digits_EIGHT, Mode: Mode_COUNTER}, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Enroll(ctx, &tt.en)
+			if tt.valid && err != nil {
+				t.Fatalf("expected enrollment to be allowed, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected enrollment to be rejected")
+			}
+		})
+	}
+}
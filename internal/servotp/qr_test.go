@@ -0,0 +1,55 @@
+package servotp
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/modfin/twofer/internal/servqr"
+)
+
+func TestGetQRImage(t *testing.T) {
+	s := newTestServer(t, OTPConfig{})
+	ctx := context.Background()
+
+	en, err := s.Import(ctx, &Import{Secret: importSecret, Issuer: "ACME", Account: "john@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png, err := s.GetQRImage(ctx, &QRReq{UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if png.ContentType != "image/png" || !bytes.HasPrefix(png.Data, []byte("\x89PNG")) {
+		t.Fatalf("expected a png image, got %s", png.ContentType)
+	}
+	if png.Secret != "" {
+		t.Fatal("expected secret to not be included unless asked for")
+	}
+
+	svg, err := s.GetQRImage(ctx, &QRReq{UserBlob: en.UserBlob, Size: 512, RecoveryLevel: servqr.Data_HIGH, Format: servqr.Format_SVG, IncludeSecret: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svg.ContentType != "image/svg+xml" || !strings.HasPrefix(string(svg.Data), "<svg") || !strings.Contains(string(svg.Data), `width="512"`) {
+		t.Fatalf("expected a 512px svg image, got %s", svg.Data)
+	}
+	if svg.Secret != "JBSW Y3DP EHPK 3PXP JBSW Y3DP EHPK 3PXP" {
+		t.Fatalf("expected grouped secret, got %q", svg.Secret)
+	}
+	if svg.Issuer != "ACME" || svg.Account != "john@example.com" {
+		t.Fatalf("expected issuer and account labels, got %q %q", svg.Issuer, svg.Account)
+	}
+
+	_, err = s.GetQRImage(ctx, &QRReq{UserBlob: en.UserBlob, RecoveryLevel: 4})
+	if err == nil {
+		t.Fatal("expected an unknown recovery level to be rejected")
+	}
+
+	_, err = s.GetQRImage(ctx, &QRReq{UserBlob: en.UserBlob, Size: MaxQRSize + 1})
+	if err == nil {
+		t.Fatal("expected a size above the maximum to be rejected")
+	}
+}
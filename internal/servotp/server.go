@@ -4,13 +4,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/modfin/twofer/internal/crypt"
 	"github.com/modfin/twofer/internal/ratelimit"
 	"github.com/modfin/twofer/internal/servqr"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/hotp"
 	"github.com/pquerna/otp/totp"
-	"github.com/skip2/go-qrcode"
 	"golang.org/x/net/context"
 	"net/url"
 	"strconv"
@@ -22,6 +22,29 @@ type OTPConfig struct {
 	SkewCounter uint
 	SkewTime    uint
 	RateLimit   uint
+
+	// MaxSkewCounter and MaxSkewTime are the upper bounds of the skew a request Policy may ask for
+	MaxSkewCounter uint
+	MaxSkewTime    uint
+	// ReplayProtection rejects a time based otp that has already been used, a request Policy may enable but not disable it
+	ReplayProtection bool
+
+	// AllowedAlgs, AllowedDigits and AllowedPeriods limits what can be enrolled, all are allowed if empty
+	AllowedAlgs    []Alg
+	AllowedDigits  []Digits
+	AllowedPeriods []uint32
+
+	// ResyncCounter is how many OTPs ahead of the current counter that are searched on resync, in counter mode
+	ResyncCounter uint
+	// ResyncTime is how many OTPs forward and backwards in time that are searched on resync, in time mode
+	ResyncTime uint
+
+	// LockoutThreshold is the number of consecutive failed attempts that are allowed before the user is locked out
+	LockoutThreshold uint
+	// LockoutBase is the lockout duration once the threshold is reached, it is doubled for each further failure
+	LockoutBase time.Duration
+	// LockoutMax caps the exponential backoff of the lockout
+	LockoutMax time.Duration
 }
 
 func New(conf OTPConfig, keys []string) (*Server, error) {
@@ -46,6 +69,30 @@ func New(conf OTPConfig, keys []string) (*Server, error) {
 
 	s.ratelimiter = ratelimit.New(s.conf.RateLimit)
 
+	if s.conf.MaxSkewCounter < s.conf.SkewCounter {
+		s.conf.MaxSkewCounter = s.conf.SkewCounter
+	}
+	if s.conf.MaxSkewTime < s.conf.SkewTime {
+		s.conf.MaxSkewTime = s.conf.SkewTime
+	}
+
+	if s.conf.ResyncCounter == 0 {
+		s.conf.ResyncCounter = 100
+	}
+	if s.conf.ResyncTime == 0 {
+		s.conf.ResyncTime = 10
+	}
+
+	if s.conf.LockoutThreshold == 0 {
+		s.conf.LockoutThreshold = 5
+	}
+	if s.conf.LockoutBase <= 0 {
+		s.conf.LockoutBase = 30 * time.Second
+	}
+	if s.conf.LockoutMax < s.conf.LockoutBase {
+		s.conf.LockoutMax = time.Hour
+	}
+
 	return s, nil
 }
 
@@ -60,17 +107,135 @@ type Server struct {
 	ratelimiter *ratelimit.Ratelimiter
 }
 
-func (s *Server) Upgrade(context.Context, *Blob) (*Blob, error) {
-	panic("implement me")
+// Upgrade re-encrypts the blob using the latest encryption key
+func (s *Server) Upgrade(_ context.Context, req *Blob) (*Blob, error) {
+	v, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+	userBlob, err := s.seal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{Ref: req.Ref, UserBlob: userBlob}, nil
 }
 
 type wrapper struct {
 	URI     string `json:"uri"`
 	Counter uint64 `json:"counter,omitempty"`
+
+	// Failures is the number of consecutive failed attempts, reset on a successful auth
+	Failures uint32 `json:"failures,omitempty"`
+	// LockedUntil is a unix timestamp, no attempts are verified before it has passed
+	LockedUntil int64 `json:"lockedUntil,omitempty"`
+
+	// Drift is the number of time steps the users TOTP clock is off, recorded on resync
+	Drift int64 `json:"drift,omitempty"`
+
+	// LastStep is the time step of the last successfully used TOTP, used for replay protection
+	LastStep uint64 `json:"lastStep,omitempty"`
+}
+
+type params struct {
+	secret string
+	digits otp.Digits
+	period uint
+	alg    otp.Algorithm
+}
+
+func (p params) opts() hotp.ValidateOpts {
+	return hotp.ValidateOpts{
+		Digits:    p.digits,
+		Algorithm: p.alg,
+	}
+}
+
+func parseParams(uri *url.URL) (params, error) {
+	p := params{
+		secret: uri.Query().Get("secret"),
+		period: 30,
+	}
+
+	switch uri.Query().Get("digits") {
+	case "6":
+		p.digits = otp.DigitsSix
+	case "8":
+		p.digits = otp.DigitsEight
+	default:
+		p.digits = otp.DigitsSix
+	}
+
+	period := uri.Query().Get("period")
+	if len(period) > 0 {
+		pp, err := strconv.ParseUint(period, 10, 32)
+		if err != nil {
+			return p, err
+		}
+		p.period = uint(pp)
+	}
+
+	switch strings.ToUpper(uri.Query().Get("algorithm")) {
+	case "SHA1":
+		p.alg = otp.AlgorithmSHA1
+	case "SHA256":
+		p.alg = otp.AlgorithmSHA256
+	case "SHA512":
+		p.alg = otp.AlgorithmSHA512
+	default:
+		p.alg = otp.AlgorithmSHA1
+	}
+	return p, nil
+}
+
+func (s *Server) open(userBlob string) (wrapper, error) {
+	var v wrapper
+	sec, err := base64.StdEncoding.DecodeString(userBlob)
+	if err != nil {
+		return v, err
+	}
+	sec, err = s.store.Decrypt(sec)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(sec, &v)
+	return v, err
+}
+
+func (s *Server) seal(v wrapper) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	b, err = s.store.Encrypt(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// fail registers a failed attempt and, once the threshold is reached, locks the user out with an exponential backoff
+func (s *Server) fail(v *wrapper, now time.Time) {
+	v.Failures++
+	if uint(v.Failures) < s.conf.LockoutThreshold {
+		return
+	}
+	lockout := s.conf.LockoutBase
+	for i := s.conf.LockoutThreshold; i < uint(v.Failures) && lockout < s.conf.LockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > s.conf.LockoutMax {
+		lockout = s.conf.LockoutMax
+	}
+	v.LockedUntil = now.Add(lockout).Unix()
 }
 
 func (s *Server) Enroll(ctx context.Context, en *Enrollment) (resp *EnrollmentResponse, err error) {
 
+	err = s.allowed(en.Alg, en.Mode, en.Digits, en.Period)
+	if err != nil {
+		return nil, err
+	}
+
 	digits := otp.DigitsSix
 	switch en.Digits {
 	case Digits_SIX:
@@ -116,35 +281,20 @@ func (s *Server) Enroll(ctx context.Context, en *Enrollment) (resp *EnrollmentRe
 		return nil, errors.New("mode must be time or counter")
 	}
 
-	b, err := json.Marshal(o)
-	if err != nil {
-		return nil, err
-	}
-
-	b, err = s.store.Encrypt(b)
+	userBlob, err := s.seal(o)
 	if err != nil {
 		return nil, err
 	}
 
 	return &EnrollmentResponse{
 		Uri:      o.URI,
-		UserBlob: base64.StdEncoding.EncodeToString(b),
+		UserBlob: userBlob,
 	}, nil
 }
 
 func (s *Server) Auth(ctx context.Context, va *Credentials) (*AuthResponse, error) {
 
-	sec, err := base64.StdEncoding.DecodeString(va.UserBlob)
-	if err != nil {
-		return nil, err
-	}
-	sec, err = s.store.Decrypt(sec)
-	if err != nil {
-		return nil, err
-	}
-
-	var v wrapper
-	err = json.Unmarshal(sec, &v)
+	v, err := s.open(va.UserBlob)
 	if err != nil {
 		return nil, err
 	}
@@ -160,66 +310,69 @@ func (s *Server) Auth(ctx context.Context, va *Credentials) (*AuthResponse, erro
 		return nil, err
 	}
 
-	var didgets otp.Digits
-	switch uri.Query().Get("digits") {
-	case "6":
-		didgets = otp.DigitsSix
-	case "8":
-		didgets = otp.DigitsEight
-	default:
-		didgets = otp.DigitsSix
+	now := time.Now().UTC()
+	if v.LockedUntil > now.Unix() {
+		return &AuthResponse{
+			Valid:       false,
+			UserBlob:    va.UserBlob,
+			LockedUntil: v.LockedUntil,
+		}, nil
 	}
-	var period uint = 30
-	p := uri.Query().Get("period")
-	if len(p) > 0 {
-		pp, err := strconv.ParseUint(p, 10, 32)
-		if err != nil {
-			return nil, err
-		}
-		period = uint(pp)
+
+	p, err := parseParams(uri)
+	if err != nil {
+		return nil, err
 	}
 
-	var alg otp.Algorithm
-	switch strings.ToUpper(uri.Query().Get("algorithm")) {
-	case "SHA1":
-		alg = otp.AlgorithmSHA1
-	case "SHA256":
-		alg = otp.AlgorithmSHA256
-	case "SHA512":
-		alg = otp.AlgorithmSHA512
-	default:
-		alg = otp.AlgorithmSHA1
+	pol, err := s.policy(va.Policy)
+	if err != nil {
+		return nil, err
+	}
+	err = pol.check(p)
+	if err != nil {
+		return nil, err
 	}
 
 	var valid bool
 	switch uri.Host {
 	case "totp":
-		valid, err = totp.ValidateCustom(va.Otp, uri.Query().Get("secret"), time.Now().UTC(), totp.ValidateOpts{
-			Period:    period,
-			Skew:      s.conf.SkewTime,
-			Digits:    didgets,
-			Algorithm: alg,
-		})
-		if err != nil {
-			return nil, err
+		if p.period == 0 {
+			return nil, errors.New("period of otp must be positive")
 		}
-	case "hotp":
-		for i := uint64(0); i <= uint64(s.conf.SkewCounter); i++ {
-			valid, err = hotp.ValidateCustom(va.Otp, v.Counter+i, uri.Query().Get("secret"), hotp.ValidateOpts{
-				Digits:    didgets,
-				Algorithm: alg,
-			})
+		current := now.Unix()/int64(p.period) + v.Drift
+		skew := int64(pol.skewTime)
+		for i := -skew; i <= skew; i++ {
+			step := current + i
+			if step < 0 {
+				continue
+			}
+			valid, err = hotp.ValidateCustom(va.Otp, uint64(step), p.secret, p.opts())
 			if err != nil {
 				return nil, err
 			}
 			if !valid {
 				continue
 			}
-			v.Counter += i + 1
-			sec, err = json.Marshal(v)
+			if pol.replayProtection && uint64(step) <= v.LastStep {
+				// The otp, or a later one, has already been used
+				valid = false
+				break
+			}
+			if uint64(step) > v.LastStep {
+				v.LastStep = uint64(step)
+			}
+			break
+		}
+	case "hotp":
+		for i := uint64(0); i <= uint64(pol.skewCounter); i++ {
+			valid, err = hotp.ValidateCustom(va.Otp, v.Counter+i, p.secret, p.opts())
 			if err != nil {
 				return nil, err
 			}
+			if !valid {
+				continue
+			}
+			v.Counter += i + 1
 			break
 		}
 
@@ -227,51 +380,181 @@ func (s *Server) Auth(ctx context.Context, va *Credentials) (*AuthResponse, erro
 		return nil, errors.New("otp scheme is not valid " + uri.Host)
 	}
 
-	sec, err = s.store.Encrypt(sec)
+	if valid {
+		v.Failures = 0
+		v.LockedUntil = 0
+	} else {
+		s.fail(&v, now)
+	}
+
+	userBlob, err := s.seal(v)
 	if err != nil {
 		return nil, err
 	}
 
 	return &AuthResponse{
-		Valid:    valid,
-		UserBlob: base64.StdEncoding.EncodeToString(sec),
+		Valid:       valid,
+		UserBlob:    userBlob,
+		LockedUntil: v.LockedUntil,
 	}, nil
 
 }
 
-func (s *Server) GetQRImage(ctx context.Context, va *Credentials) (*servqr.Image, error) {
-	sec, err := base64.StdEncoding.DecodeString(va.UserBlob)
+// Resync searches a larger window than Auth for two consecutive OTPs. In counter mode the counter is moved past the
+// second OTP, in time mode the drift of the users clock is recorded in the blob and used for subsequent auths
+func (s *Server) Resync(ctx context.Context, re *Resync) (*AuthResponse, error) {
+	if re.Otp == "" || re.NextOtp == "" {
+		return nil, errors.New("two consecutive otps must be provided")
+	}
+
+	v, err := s.open(re.UserBlob)
 	if err != nil {
 		return nil, err
 	}
-	sec, err = s.store.Decrypt(sec)
+
+	uri, err := url.Parse(v.URI)
 	if err != nil {
 		return nil, err
 	}
 
-	var v wrapper
-	err = json.Unmarshal(sec, &v)
+	err = s.ratelimiter.Hit(uri.Host + uri.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	data := servqr.Data{
-		RecoveryLevel: 0,
-		Size:          0,
-		Data:          v.URI,
+	now := time.Now().UTC()
+	if v.LockedUntil > now.Unix() {
+		return &AuthResponse{
+			Valid:       false,
+			UserBlob:    re.UserBlob,
+			LockedUntil: v.LockedUntil,
+		}, nil
+	}
+
+	p, err := parseParams(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// consecutive checks if the otps are valid for counter c and c+1
+	consecutive := func(c uint64) (bool, error) {
+		valid, err := hotp.ValidateCustom(re.Otp, c, p.secret, p.opts())
+		if err != nil || !valid {
+			return false, err
+		}
+		return hotp.ValidateCustom(re.NextOtp, c+1, p.secret, p.opts())
+	}
+
+	var valid bool
+	switch uri.Host {
+	case "totp":
+		if p.period == 0 {
+			return nil, errors.New("period of otp must be positive")
+		}
+		current := now.Unix() / int64(p.period)
+		window := int64(s.conf.ResyncTime)
+		// The search is around the recorded drift, since the clock is likely to keep drifting in the same direction
+		for i := -window; i <= window; i++ {
+			step := current + v.Drift + i
+			if step < 0 {
+				continue
+			}
+			valid, err = consecutive(uint64(step))
+			if err != nil {
+				return nil, err
+			}
+			if valid {
+				// The second otp is the most recent one, the drift is relative to it, and it's now used
+				v.Drift = step + 1 - current
+				if uint64(step+1) > v.LastStep {
+					v.LastStep = uint64(step + 1)
+				}
+				break
+			}
+		}
+	case "hotp":
+		for i := uint64(0); i <= uint64(s.conf.ResyncCounter); i++ {
+			valid, err = consecutive(v.Counter + i)
+			if err != nil {
+				return nil, err
+			}
+			if valid {
+				v.Counter += i + 2
+				break
+			}
+		}
+	default:
+		return nil, errors.New("otp scheme is not valid " + uri.Host)
 	}
 
-	size := int(data.Size)
+	if valid {
+		v.Failures = 0
+		v.LockedUntil = 0
+	} else {
+		s.fail(&v, now)
+	}
 
-	if size < 10 {
-		size = 256
+	userBlob, err := s.seal(v)
+	if err != nil {
+		return nil, err
 	}
 
-	level := qrcode.RecoveryLevel(data.RecoveryLevel)
-	image, err := qrcode.Encode(data.Data, level, size)
+	return &AuthResponse{
+		Valid:       valid,
+		UserBlob:    userBlob,
+		LockedUntil: v.LockedUntil,
+	}, nil
+}
+
+// MaxQRSize is the largest QR image, in pixels, that can be requested
+const MaxQRSize = 2048
+
+func (s *Server) GetQRImage(ctx context.Context, req *QRReq) (*QRRes, error) {
+	if req.Size > MaxQRSize {
+		return nil, fmt.Errorf("size may not exceed %d pixels", MaxQRSize)
+	}
+	if _, ok := servqr.Data_Recovery_name[int32(req.RecoveryLevel)]; !ok {
+		return nil, errors.New("recovery level must be LOW, MEDIUM, HIGH or HIGHEST")
+	}
+
+	v, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
 
-	return &servqr.Image{
-		Data:        image,
-		ContentType: "image/png",
-	}, err
+	image, err := servqr.Encode(&servqr.Data{
+		RecoveryLevel: req.RecoveryLevel,
+		Size:          req.Size,
+		Data:          v.URI,
+		Format:        req.Format,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := otp.NewKeyFromURL(v.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &QRRes{
+		ContentType: image.ContentType,
+		Data:        image.Data,
+		Issuer:      key.Issuer(),
+		Account:     key.AccountName(),
+	}
+	if req.IncludeSecret {
+		res.Secret = groupSecret(key.Secret())
+	}
+	return res, nil
+}
+
+// groupSecret splits the secret in groups of four characters, to make it easier to type
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
 }
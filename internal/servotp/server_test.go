@@ -0,0 +1,291 @@
+package servotp
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+func newTestServer(t *testing.T, conf OTPConfig) *Server {
+	t.Helper()
+	s, err := New(conf, []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="})
+	if err != nil {
+		t.Fatalf("New(...) returned error: %v", err)
+	}
+	return s
+}
+
+func secretOf(t *testing.T, uri string) string {
+	t.Helper()
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("secret")
+}
+
+func TestAuthLockout(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, LockoutThreshold: 3, LockoutBase: time.Minute, LockoutMax: time.Hour})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "lockout", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := en.UserBlob
+	for i := 0; i < 3; i++ {
+		res, err := s.Auth(ctx, &Credentials{Otp: "000000", UserBlob: blob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Valid {
+			t.Fatal("expected invalid otp")
+		}
+		if i < 2 && res.LockedUntil != 0 {
+			t.Fatalf("expected no lockout after %d failures", i+1)
+		}
+		blob = res.UserBlob
+	}
+
+	v, err := s.open(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Failures != 3 || v.LockedUntil < time.Now().Add(time.Minute-time.Second).Unix() {
+		t.Fatalf("expected user to be locked out, got failures=%d lockedUntil=%d", v.Failures, v.LockedUntil)
+	}
+
+	code, err := totp.GenerateCode(secretOf(t, en.Uri), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.LockedUntil == 0 {
+		t.Fatal("expected a correct otp to be rejected while locked out")
+	}
+
+	// Pretend the lockout has passed
+	v.LockedUntil = time.Now().Add(-time.Second).Unix()
+	blob, err = s.seal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid after lockout passed")
+	}
+	v, err = s.open(res.UserBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Failures != 0 || v.LockedUntil != 0 {
+		t.Fatalf("expected lockout to be reset, got failures=%d lockedUntil=%d", v.Failures, v.LockedUntil)
+	}
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	s := newTestServer(t, OTPConfig{LockoutThreshold: 2, LockoutBase: time.Minute, LockoutMax: 5 * time.Minute})
+	now := time.Now()
+
+	var v wrapper
+	expected := []time.Duration{0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, e := range expected {
+		s.fail(&v, now)
+		var got time.Duration
+		if v.LockedUntil != 0 {
+			got = time.Unix(v.LockedUntil, 0).Sub(now.Truncate(time.Second))
+		}
+		if got != e {
+			t.Fatalf("failure %d: expected lockout %v, got %v", i+1, e, got)
+		}
+	}
+}
+
+func TestResyncCounter(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewCounter: 5, ResyncCounter: 100})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "resync", Mode: Mode_COUNTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := secretOf(t, en.Uri)
+
+	// The token has been pressed 50 times without being used
+	code1, _ := hotp.GenerateCode(secret, 51)
+	code2, _ := hotp.GenerateCode(secret, 52)
+	code3, _ := hotp.GenerateCode(secret, 53)
+
+	res, err := s.Auth(ctx, &Credentials{Otp: code1, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected otp outside of skew to be invalid")
+	}
+
+	res, err = s.Resync(ctx, &Resync{Otp: code1, NextOtp: code2, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected resync to succeed")
+	}
+
+	res, err = s.Auth(ctx, &Credentials{Otp: code3, UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp following the resynced ones to be valid")
+	}
+}
+
+func TestResyncTime(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewTime: 1, ResyncTime: 10})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "resync", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := secretOf(t, en.Uri)
+
+	// The users clock is 5 minutes ahead
+	clock := time.Now().UTC().Add(5 * time.Minute)
+	code1, _ := totp.GenerateCode(secret, clock.Add(-30*time.Second))
+	code2, _ := totp.GenerateCode(secret, clock)
+
+	res, err := s.Auth(ctx, &Credentials{Otp: code2, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected otp outside of skew to be invalid")
+	}
+
+	res, err = s.Resync(ctx, &Resync{Otp: code1, NextOtp: code2, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected resync to succeed")
+	}
+
+	v, err := s.open(res.UserBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Drift < 9 || v.Drift > 11 {
+		t.Fatalf("expected a drift of about 10 steps, got %d", v.Drift)
+	}
+
+	res, err = s.Auth(ctx, &Credentials{Otp: code2, UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid once drift is recorded")
+	}
+}
+
+func TestResyncTimeReplay(t *testing.T) {
+	s := newTestServer(t, OTPConfig{RateLimit: 100, SkewTime: 1, ResyncTime: 10, ReplayProtection: true})
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "resync", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := secretOf(t, en.Uri)
+
+	resync := func(userBlob string, clock time.Time) (string, string) {
+		t.Helper()
+		code1, _ := totp.GenerateCode(secret, clock.Add(-30*time.Second))
+		code2, _ := totp.GenerateCode(secret, clock)
+		res, err := s.Resync(ctx, &Resync{Otp: code1, NextOtp: code2, UserBlob: userBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Valid {
+			t.Fatal("expected resync to succeed")
+		}
+		return code2, res.UserBlob
+	}
+
+	// The users clock is 5 minutes ahead, and then drifts another 3 minutes, which is outside of the resync window
+	// from the current time but within it from the recorded drift
+	now := time.Now().UTC()
+	_, userBlob := resync(en.UserBlob, now.Add(5*time.Minute))
+	next, userBlob := resync(userBlob, now.Add(8*time.Minute))
+
+	res, err := s.Auth(ctx, &Credentials{Otp: next, UserBlob: userBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected the otp used to resync to be rejected")
+	}
+
+	code, _ := totp.GenerateCode(secret, now.Add(8*time.Minute+30*time.Second))
+	res, err = s.Auth(ctx, &Credentials{Otp: code, UserBlob: userBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected the otp following the resynced ones to be valid")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	ctx := context.Background()
+	old := newTestServer(t, OTPConfig{})
+	en, err := old.Enroll(ctx, &Enrollment{Issuer: "twofer", Account: "upgrade", Mode: Mode_TIME})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(OTPConfig{RateLimit: 100}, []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w==", "2:aes:7dd8jQ4JqDDk1H2Vr9WKbw=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := s.Upgrade(ctx, &Blob{Ref: "a", UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Ref != "a" || up.UserBlob == en.UserBlob {
+		t.Fatal("expected a re-encrypted blob with the same ref")
+	}
+	_, err = old.open(up.UserBlob)
+	if err == nil {
+		t.Fatal("expected upgraded blob to be encrypted with the new key")
+	}
+
+	code, err := totp.GenerateCode(secretOf(t, en.Uri), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &Credentials{Otp: code, UserBlob: up.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected otp to be valid against upgraded blob")
+	}
+
+	_, err = s.Upgrade(ctx, &Blob{UserBlob: "bm90IGEgYmxvYg=="})
+	if err == nil {
+		t.Fatal("expected error for invalid blob")
+	}
+}
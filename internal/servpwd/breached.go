@@ -0,0 +1,97 @@
+package servpwd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RangeSource returns the Have I Been Pwned range for a 5 character SHA-1 prefix, that is lines of
+// "<35 character suffix>:<count>". Only the prefix ever leaves the server, which keeps the password k-anonymous
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) (io.ReadCloser, error)
+}
+
+// RangeDir reads ranges from a local directory with one file per prefix, eg. 5BAA6.txt, as created by the
+// PwnedPasswordsDownloader
+type RangeDir string
+
+func (d RangeDir) Range(_ context.Context, prefix string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return f, err
+}
+
+// RangeAPI fetches ranges from the Have I Been Pwned range API, or any other service implementing it
+type RangeAPI struct {
+	URL    string
+	client *http.Client
+}
+
+// NewRangeAPI creates a RangeAPI, if url is empty the public Have I Been Pwned API is used
+func NewRangeAPI(url string, timeout time.Duration) *RangeAPI {
+	if url == "" {
+		url = "https://api.pwnedpasswords.com/range/"
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &RangeAPI{URL: strings.TrimSuffix(url, "/") + "/", client: &http.Client{Timeout: timeout}}
+}
+
+func (a *RangeAPI) Range(ctx context.Context, prefix string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Padding makes all responses about the same size, so the prefix can't be inferred from the response
+	req.Header.Set("Add-Padding", "true")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("range api responded with status code: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// breached returns how many times the password has been seen in breaches
+func breached(ctx context.Context, src RangeSource, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	r, err := src.Range(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s, c, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(c)
+		if err != nil {
+			return 0, fmt.Errorf("invalid count in range: %w", err)
+		}
+		return count, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,298 @@
+package servpwd
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Formats of password hashes that can be imported from other systems
+const (
+	LegacyBCrypt       = "bcrypt"        // $2a$, $2b$ and $2y$
+	LegacyArgon2id     = "argon2id"      // PHC string, $argon2id$v=19$m=...,t=...,p=...$salt$hash
+	LegacyPBKDF2SHA256 = "pbkdf2_sha256" // Django, pbkdf2_sha256$iterations$salt$hash
+	LegacySHA512Crypt  = "sha512_crypt"  // crypt(3), $6$[rounds=N$]salt$hash
+)
+
+// The highest costs accepted for imported hashes. The parameters come from the hash itself, so without a cap a single
+// imported hash could exhaust the memory, or tie up a CPU, on every auth
+const (
+	legacyBCryptCostMax        = 16
+	legacyArgon2MemoryMax      = 256 * 1024 // KiB
+	legacyArgon2TimeMax        = 16
+	legacyArgon2ParallelMax    = 16
+	legacyArgon2KeyLenMax      = 128
+	legacyPBKDF2IterationsMax  = 2_000_000
+	legacySHA512CryptRoundsMax = 1_000_000
+)
+
+type legacyMetadata struct {
+	Format string `json:"format"`
+}
+
+// legacyFormat detects the format of an imported hash and validates that it can be parsed
+func legacyFormat(hash string) (string, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := checkBCryptCost(hash)
+		if err != nil {
+			return "", err
+		}
+		return LegacyBCrypt, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, err := parseArgon2id(hash)
+		if err != nil {
+			return "", err
+		}
+		return LegacyArgon2id, nil
+	case strings.HasPrefix(hash, "pbkdf2_sha256$"):
+		_, _, _, err := parsePBKDF2SHA256(hash)
+		if err != nil {
+			return "", err
+		}
+		return LegacyPBKDF2SHA256, nil
+	case strings.HasPrefix(hash, "$6$"):
+		_, _, _, err := parseSHA512Crypt(hash)
+		if err != nil {
+			return "", err
+		}
+		return LegacySHA512Crypt, nil
+	}
+	return "", errors.New("unsupported hash format")
+}
+
+func verifyLegacy(format string, hash string, password string) (bool, error) {
+	switch format {
+	case LegacyBCrypt:
+		err := checkBCryptCost(hash)
+		if err != nil {
+			return false, err
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil && err != bcrypt.ErrMismatchedHashAndPassword {
+			return false, err
+		}
+		return err == nil, nil
+	case LegacyArgon2id:
+		m, salt, digest, err := parseArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		newDigest := argon2.IDKey([]byte(password), salt, m.Time, m.Memory, m.Parallelism, m.KeyLen)
+		return subtle.ConstantTimeCompare(newDigest, digest) == 1, nil
+	case LegacyPBKDF2SHA256:
+		iterations, salt, digest, err := parsePBKDF2SHA256(hash)
+		if err != nil {
+			return false, err
+		}
+		newDigest, err := pbkdf2.Key(sha256.New, password, []byte(salt), iterations, len(digest))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(newDigest, digest) == 1, nil
+	case LegacySHA512Crypt:
+		rounds, salt, digest, err := parseSHA512Crypt(hash)
+		if err != nil {
+			return false, err
+		}
+		newDigest := sha512Crypt([]byte(password), []byte(salt), rounds)
+		return subtle.ConstantTimeCompare([]byte(newDigest), []byte(digest)) == 1, nil
+	}
+	return false, fmt.Errorf("unsupported legacy format %q", format)
+}
+
+func checkBCryptCost(hash string) error {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > legacyBCryptCostMax {
+		return fmt.Errorf("bcrypt cost %d exceeds the maximum of %d", cost, legacyBCryptCostMax)
+	}
+	return nil
+}
+
+// parseArgon2id parses a PHC string, $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, where salt and hash are unpadded
+// base64
+func parseArgon2id(hash string) (argon2Metadata, []byte, []byte, error) {
+	var m argon2Metadata
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return m, nil, nil, errors.New("invalid argon2id hash")
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return m, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m.Memory, &m.Time, &m.Parallelism)
+	if err != nil {
+		return m, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if m.Time < 1 || m.Parallelism < 1 {
+		return m, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	if m.Memory > legacyArgon2MemoryMax || m.Time > legacyArgon2TimeMax || m.Parallelism > legacyArgon2ParallelMax {
+		return m, nil, nil, fmt.Errorf("argon2id parameters exceed the maximum of m=%d,t=%d,p=%d",
+			legacyArgon2MemoryMax, legacyArgon2TimeMax, legacyArgon2ParallelMax)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return m, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	digest, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(digest) == 0 || len(digest) > legacyArgon2KeyLenMax {
+		return m, nil, nil, errors.New("invalid argon2id hash")
+	}
+	m.KeyLen = uint32(len(digest))
+	return m, salt, digest, nil
+}
+
+// parsePBKDF2SHA256 parses the Django format, pbkdf2_sha256$<iterations>$<salt>$<base64 hash>
+func parsePBKDF2SHA256(hash string) (int, string, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return 0, "", nil, errors.New("invalid pbkdf2_sha256 hash")
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, "", nil, errors.New("invalid pbkdf2_sha256 iterations")
+	}
+	if iterations > legacyPBKDF2IterationsMax {
+		return 0, "", nil, fmt.Errorf("pbkdf2_sha256 iterations exceed the maximum of %d", legacyPBKDF2IterationsMax)
+	}
+	digest, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(digest) == 0 {
+		return 0, "", nil, errors.New("invalid pbkdf2_sha256 hash")
+	}
+	return iterations, parts[2], digest, nil
+}
+
+const (
+	sha512CryptRoundsDefault = 5000
+	sha512CryptRoundsMin     = 1000
+	sha512CryptSaltMax       = 16
+)
+
+// parseSHA512Crypt parses $6$[rounds=<N>$]<salt>$<hash>
+func parseSHA512Crypt(hash string) (int, string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(hash, "$6$"), "$")
+	rounds := sha512CryptRoundsDefault
+	if len(parts) == 3 && strings.HasPrefix(parts[0], "rounds=") {
+		var err error
+		rounds, err = strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil {
+			return 0, "", "", errors.New("invalid sha512_crypt rounds")
+		}
+		if rounds > legacySHA512CryptRoundsMax {
+			return 0, "", "", fmt.Errorf("sha512_crypt rounds exceed the maximum of %d", legacySHA512CryptRoundsMax)
+		}
+		rounds = max(rounds, sha512CryptRoundsMin)
+		parts = parts[1:]
+	}
+	if len(parts) != 2 || len(parts[1]) != 86 {
+		return 0, "", "", errors.New("invalid sha512_crypt hash")
+	}
+	salt := parts[0]
+	if len(salt) > sha512CryptSaltMax {
+		salt = salt[:sha512CryptSaltMax]
+	}
+	return rounds, salt, parts[1], nil
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512CryptOrder is the order the digest bytes are encoded in, three bytes at a time
+var sha512CryptOrder = [21][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// sha512Crypt implements the SHA-512 based crypt(3) by Ulrich Drepper, returning the encoded hash without the salt
+func sha512Crypt(password []byte, salt []byte, rounds int) string {
+	b := sha512.New()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(password)
+	a.Write(salt)
+	for i := len(password); i > 0; i -= sha512.Size {
+		a.Write(digestB[:min(i, sha512.Size)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := sha512.New()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeat(dp.Sum(nil), len(password))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeat(ds.Sum(nil), len(salt))
+
+	c := digestA
+	for r := 0; r < rounds; r++ {
+		h := sha512.New()
+		if r&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if r%3 != 0 {
+			h.Write(s)
+		}
+		if r%7 != 0 {
+			h.Write(p)
+		}
+		if r&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, o := range sha512CryptOrder {
+		encode(c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	encode(0, 0, c[63], 2)
+	return out.String()
+}
+
+// repeat returns n bytes of the digest repeated
+func repeat(digest []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, digest[:min(n-len(out), len(digest))]...)
+	}
+	return out
+}
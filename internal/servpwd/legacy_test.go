@@ -0,0 +1,88 @@
+package servpwd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestImportLegacy(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(PWDConfig{DefaultAlg: Alg_BCrypt, DefaultBCryptCost: 4}, []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err := bcrypt.GenerateFromPassword([]byte("correct horse"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("somesaltsomesalt")
+	a2 := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("correct horse"), salt, 1, 1024, 1, 32)))
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+	}{
+		{name: "bcrypt", hash: string(bc), password: "correct horse"},
+		{name: "argon2id", hash: a2, password: "correct horse"},
+		{name: "pbkdf2_sha256", hash: "pbkdf2_sha256$260000$c2FsdHNhbHQ$mjK5agjG0EjxjNkwpD+iSMW1828PCNVmp+KMvPzzl0A=", password: "correct horse"},
+		{name: "sha512_crypt", hash: "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", password: "Hello world!"},
+		{name: "sha512_crypt rounds", hash: "$6$rounds=1000$saltsalt$4GMtaIz3E1AdDi2SmCokEW0ehi.HdxNDcL3fGE1XXuzvo6kbx7UOloZKONqEk5H3JrQA4NOfU8BmqFPrpGqwA1", password: "correct horse"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blob, err := s.Import(ctx, &ImportReq{Hash: test.hash})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := s.Auth(ctx, &AuthReq{Password: "wrong horse", UserBlob: blob.UserBlob})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Valid || res.UserBlob != "" {
+				t.Fatal("expected wrong password to be invalid")
+			}
+
+			res, err = s.Auth(ctx, &AuthReq{Password: test.password, UserBlob: blob.UserBlob})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Valid {
+				t.Fatal("expected password to be valid")
+			}
+			if res.UserBlob == "" {
+				t.Fatal("expected legacy hash to be rehashed")
+			}
+
+			res, err = s.Auth(ctx, &AuthReq{Password: test.password, UserBlob: res.UserBlob})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Valid || res.UserBlob != "" {
+				t.Fatal("expected rehashed blob to be valid and up to date")
+			}
+		})
+	}
+
+	for _, hash := range []string{"", "$1$salt$hash", "$argon2id$v=19$m=1024$c2FsdA$aGFzaA", "pbkdf2_sha256$x$salt$aGFzaA==", "$6$salt$short",
+		// Costs above the caps
+		"$2a$31$" + string(bc[7:]),
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=4294967295,p=1$c2FsdA$aGFzaA",
+		"pbkdf2_sha256$2147483647$c2FsdHNhbHQ$mjK5agjG0EjxjNkwpD+iSMW1828PCNVmp+KMvPzzl0A=",
+		"$6$rounds=999999999$saltsalt$4GMtaIz3E1AdDi2SmCokEW0ehi.HdxNDcL3fGE1XXuzvo6kbx7UOloZKONqEk5H3JrQA4NOfU8BmqFPrpGqwA1",
+	} {
+		_, err = s.Import(ctx, &ImportReq{Hash: hash})
+		if err == nil {
+			t.Fatalf("expected import of %q to fail", hash)
+		}
+	}
+}
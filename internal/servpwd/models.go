@@ -3,10 +3,13 @@ package servpwd
 type Alg int32
 
 const (
-	Alg_SHA_256 Alg = 0
-	Alg_SHA_512 Alg = 1
-	Alg_SCrypt  Alg = 2
-	Alg_BCrypt  Alg = 3
+	Alg_SHA_256  Alg = 0
+	Alg_SHA_512  Alg = 1
+	Alg_SCrypt   Alg = 2
+	Alg_BCrypt   Alg = 3
+	Alg_Argon2id Alg = 4
+	// Alg_Legacy is used for hashes imported from other systems, it can't be used as default
+	Alg_Legacy Alg = 5
 )
 
 var Alg_name = map[int32]string{
@@ -14,16 +17,28 @@ var Alg_name = map[int32]string{
 	1: "SHA_512",
 	2: "SCrypt",
 	3: "BCrypt",
+	4: "Argon2id",
+	5: "Legacy",
 }
 var Alg_value = map[string]int32{
-	"SHA_256": 0,
-	"SHA_512": 1,
-	"SCrypt":  2,
-	"BCrypt":  3,
+	"SHA_256":  0,
+	"SHA_512":  1,
+	"SCrypt":   2,
+	"BCrypt":   3,
+	"Argon2id": 4,
+	"Legacy":   5,
 }
 
 type EnrollReq struct {
 	Password string `json:"password,omitempty"`
+	// Username is optional, if set the password may not contain it
+	Username string `json:"username,omitempty"`
+}
+
+// ImportReq holds a password hash from another system, in one of the formats $2a$/$2b$/$2y$ (bcrypt), $argon2id$
+// (PHC string), pbkdf2_sha256$ (Django) or $6$ (SHA-512 crypt)
+type ImportReq struct {
+	Hash string `json:"hash,omitempty"`
 }
 
 type AuthReq struct {
@@ -31,11 +46,34 @@ type AuthReq struct {
 	UserBlob string `json:"userBlob,omitempty"`
 }
 
+type ChangeReq struct {
+	OldPassword string `json:"oldPassword,omitempty"`
+	NewPassword string `json:"newPassword,omitempty"`
+	// Username is optional, if set the new password may not contain it
+	Username string `json:"username,omitempty"`
+	UserBlob string `json:"userBlob,omitempty"`
+}
+
+type ChangeRes struct {
+	// Valid is true if the old password was valid
+	Valid   bool   `json:"valid,omitempty"`
+	Message string `json:"message,omitempty"`
+	// UserBlob is set when the password has been changed, it shall replace the persisted blob
+	UserBlob string `json:"userBlob,omitempty"`
+	// Violations is set, instead of UserBlob, when the new password doesn't comply with the policy
+	Violations []Violation `json:"violations,omitempty"`
+}
+
 type Res struct {
 	Valid   bool   `json:"valid,omitempty"`
 	Message string `json:"message,omitempty"`
+	// UserBlob is set when the password was valid but the blob was hashed with outdated parameters, it is rehashed using
+	// the current defaults and shall replace the persisted blob
+	UserBlob string `json:"userBlob,omitempty"`
 }
 
 type Blob struct {
 	UserBlob string `json:"userBlob,omitempty"`
+	// Violations is set, instead of UserBlob, when a password on enroll doesn't comply with the policy
+	Violations []Violation `json:"violations,omitempty"`
 }
@@ -0,0 +1,68 @@
+package servpwd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type peppers struct {
+	max  uint32
+	keys map[uint32][]byte
+}
+
+// newPeppers parses peppers in the form version:base64, eg. "1:c2VjcmV0IHBlcHBlciAxMjM0NQ==". The highest version is
+// used for new hashes. Version 0 is reserved for hashes without pepper
+func newPeppers(keys []string) (peppers, error) {
+	p := peppers{keys: map[uint32][]byte{}}
+	for _, k := range keys {
+		parts := strings.Split(k, ":")
+		if len(parts) != 2 {
+			return p, errors.New("a pepper shall consist of two portions. version:pepper")
+		}
+		vv, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil || vv == 0 {
+			return p, fmt.Errorf("version of pepper is expected to be an uint above 0: %s", parts[0])
+		}
+		raw, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return p, fmt.Errorf("pepper is expected to be in base64 std encoding: %v", err)
+		}
+		if len(raw) < 16 {
+			return p, errors.New("pepper is expected to be at least 16 bytes")
+		}
+		version := uint32(vv)
+		if _, ok := p.keys[version]; ok {
+			return p, fmt.Errorf("there seems to be duplicate version of pepper %d", version)
+		}
+		p.keys[version] = raw
+		if version > p.max {
+			p.max = version
+		}
+	}
+	return p, nil
+}
+
+// apply returns the password peppered with the given version, HMAC-SHA256 keyed with the pepper and base64 encoded
+func (p peppers) apply(password string, version uint32) (string, error) {
+	if version == 0 {
+		return password, nil
+	}
+	key, ok := p.keys[version]
+	if !ok {
+		return "", fmt.Errorf("pepper version %d is not configured", version)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return Base64Encode(mac.Sum(nil)), nil
+}
+
+// has reports whether passwords peppered with the version can be verified
+func (p peppers) has(version uint32) bool {
+	_, ok := p.keys[version]
+	return version == 0 || ok
+}
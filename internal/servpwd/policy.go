@@ -0,0 +1,101 @@
+package servpwd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation codes returned when a password doesn't comply with the policy
+const (
+	ViolationTooShort   = "too_short"
+	ViolationTooLong    = "too_long"
+	ViolationRepetitive = "repetitive"
+	ViolationSequential = "sequential"
+	ViolationContext    = "context"
+	ViolationBreached   = "breached"
+	ViolationReused     = "reused"
+)
+
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// check validates the password against the policy, following NIST 800-63B there are no composition rules. Instead
+// passwords that are short, repetitive or sequential, contain the username or a context word, or have been seen in
+// breaches are rejected
+func (s *Server) check(ctx context.Context, password string, username string) ([]Violation, error) {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if s.conf.MinLength > 0 && length < s.conf.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters", s.conf.MinLength),
+		})
+	}
+	if s.conf.MaxLength > 0 && length > s.conf.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("password may not be more than %d characters", s.conf.MaxLength),
+		})
+	}
+	if length > 1 && repetitive(password) {
+		violations = append(violations, Violation{Code: ViolationRepetitive, Message: "password may not be a single repeated character"})
+	}
+	if length > 2 && sequential(password) {
+		violations = append(violations, Violation{Code: ViolationSequential, Message: "password may not be a sequence of characters"})
+	}
+
+	lower := strings.ToLower(password)
+	words := append([]string{username}, s.conf.ContextWords...)
+	for _, w := range words {
+		// Short words would match far too many passwords
+		if utf8.RuneCountInString(w) < 3 {
+			continue
+		}
+		if strings.Contains(lower, strings.ToLower(w)) {
+			violations = append(violations, Violation{Code: ViolationContext, Message: "password may not contain the username or the name of the service"})
+			break
+		}
+	}
+
+	if s.conf.Breached != nil && len(violations) == 0 {
+		count, err := breached(ctx, s.conf.Breached, password)
+		if err != nil {
+			return nil, fmt.Errorf("could not check password against breaches: %w", err)
+		}
+		if count >= s.conf.BreachedThreshold {
+			violations = append(violations, Violation{Code: ViolationBreached, Message: "password has been seen in a data breach"})
+		}
+	}
+
+	return violations, nil
+}
+
+func repetitive(password string) bool {
+	first, _ := utf8.DecodeRuneInString(password)
+	for _, r := range password {
+		if r != first {
+			return false
+		}
+	}
+	return true
+}
+
+// sequential reports whether each character is one above, or each one below, the previous one, eg. 12345678 or zyxwvu
+func sequential(password string) bool {
+	runes := []rune(password)
+	step := runes[1] - runes[0]
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package servpwd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func codes(violations []Violation) []string {
+	var c []string
+	for _, v := range violations {
+		c = append(c, v.Code)
+	}
+	return c
+}
+
+func TestEnrollPolicy(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, MinLength: 8, MaxLength: 20, ContextWords: []string{"twofer"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		username string
+		expected []string
+	}{
+		{password: "", expected: []string{ViolationTooShort}},
+		{password: "short", expected: []string{ViolationTooShort}},
+		{password: "correct horse battery staple", expected: []string{ViolationTooLong}},
+		{password: "aaaaaaaaaa", expected: []string{ViolationRepetitive}},
+		{password: "12345678", expected: []string{ViolationSequential}},
+		{password: "hgfedcba", expected: []string{ViolationSequential}},
+		{password: "my Twofer password", expected: []string{ViolationContext}},
+		{password: "secret-alice-99", username: "Alice", expected: []string{ViolationContext}},
+		{password: "correct horse", username: "al"},
+		{password: "lösenord med åäö"},
+	}
+	for _, test := range tests {
+		blob, err := s.Enroll(ctx, &EnrollReq{Password: test.password, Username: test.username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := codes(blob.Violations); !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("password %q: expected violations %v, got %v", test.password, test.expected, got)
+		}
+		if (blob.UserBlob == "") != (len(test.expected) > 0) {
+			t.Fatalf("password %q: expected a userBlob only when there are no violations", test.password)
+		}
+	}
+}
+
+func TestLengthPolicyDisabled(t *testing.T) {
+	ctx := context.Background()
+	_, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, MinLength: -1}, nil)
+	if err == nil {
+		t.Fatal("expected a negative length to be rejected")
+	}
+
+	// Without a min and max length, any length is allowed
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"pin", strings.Repeat("correct horse battery staple ", 10)} {
+		blob, err := s.Enroll(ctx, &EnrollReq{Password: password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blob.Violations) > 0 || blob.UserBlob == "" {
+			t.Fatalf("password %q: expected no violations, got %v", password, codes(blob.Violations))
+		}
+	}
+}
+
+func sha1Range(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return digest[:5], digest[5:]
+}
+
+func TestBreached(t *testing.T) {
+	ctx := context.Background()
+	password := "correct horse battery"
+	prefix, suffix := sha1Range(password)
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte("0000000000000000000000000000000000A:3\r\n"+suffix+":42\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requested string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		_, _ = w.Write([]byte(suffix + ":42\r\n0000000000000000000000000000000000B:0\r\n"))
+	}))
+	defer api.Close()
+
+	for name, src := range map[string]RangeSource{"dir": RangeDir(dir), "api": NewRangeAPI(api.URL, 0)} {
+		t.Run(name, func(t *testing.T) {
+			s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, Breached: src}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob, err := s.Enroll(ctx, &EnrollReq{Password: password})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := codes(blob.Violations); !reflect.DeepEqual(got, []string{ViolationBreached}) {
+				t.Fatalf("expected password to be breached, got %v", got)
+			}
+			blob, err = s.Enroll(ctx, &EnrollReq{Password: "not in any breach"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blob.Violations) > 0 || blob.UserBlob == "" {
+				t.Fatalf("expected password to be accepted, got %v", codes(blob.Violations))
+			}
+		})
+	}
+	if strings.Contains(requested, suffix) {
+		t.Fatal("expected only the prefix to be sent to the range api")
+	}
+
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, Breached: RangeDir(dir), BreachedThreshold: 100}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := s.Enroll(ctx, &EnrollReq{Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blob.Violations) > 0 {
+		t.Fatal("expected password below the threshold to be accepted")
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/modfin/twofer/internal/crypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/scrypt"
+	"runtime"
 )
 
 type PWDConfig struct {
@@ -18,11 +20,42 @@ type PWDConfig struct {
 	DefaultSCryptR      int
 	DefaultSCryptP      int
 	DefaultSCryptKeyLen int
+	// DefaultArgon2Memory is in KiB
+	DefaultArgon2Memory      uint32
+	DefaultArgon2Time        uint32
+	DefaultArgon2Parallelism uint8
+	DefaultArgon2KeyLen      uint32
+
+	// MinLength and MaxLength are in characters, a bound that is 0 is not enforced
+	MinLength int
+	MaxLength int
+	// ContextWords may not be part of a password, eg. the name of the service
+	ContextWords []string
+	// Breached, if set, is used to reject passwords that have been seen in at least BreachedThreshold breaches
+	Breached          RangeSource
+	BreachedThreshold int
+
+	// Peppers, in the form version:base64, are applied to passwords with HMAC before hashing. The highest version is
+	// used for new hashes, older versions are kept to verify, and rotate, existing ones
+	Peppers []string
+
+	// HistorySize is how many of the most recent passwords, including the current one, that can't be reused on change.
+	// The current password is always checked, so 0 and 1 both only prevent it from being reused
+	HistorySize int
+
+	// MaxConcurrent is how many hashes that may be computed at the same time, further requests wait for a slot.
+	// Defaults to the number of CPUs
+	MaxConcurrent int
 }
 
 type Server struct {
-	store crypt.Store
-	conf  PWDConfig
+	store   crypt.Store
+	conf    PWDConfig
+	peppers peppers
+	// work bounds how many hashes that are computed concurrently
+	work chan struct{}
+	// dummy is verified against when there is no userBlob, so that unknown users cost the same as known ones
+	dummy wrapper
 }
 
 func New(conf PWDConfig, keys []string) (*Server, error) {
@@ -38,6 +71,33 @@ func New(conf PWDConfig, keys []string) (*Server, error) {
 	if s.store == nil {
 		s.store = &crypt.NilStore{}
 	}
+	s.peppers, err = newPeppers(conf.Peppers)
+	if err != nil {
+		return nil, err
+	}
+	if conf.DefaultAlg == Alg_Legacy {
+		return nil, fmt.Errorf("legacy can't be used as default alg")
+	}
+	if conf.DefaultAlg == Alg_Argon2id && (conf.DefaultArgon2Time < 1 || conf.DefaultArgon2Parallelism < 1 || conf.DefaultArgon2KeyLen < 1) {
+		return nil, fmt.Errorf("argon2id time, parallelism and key length must be at least 1")
+	}
+	if conf.HistorySize < 0 {
+		return nil, fmt.Errorf("history size can't be negative")
+	}
+	if conf.MinLength < 0 || conf.MaxLength < 0 {
+		return nil, fmt.Errorf("min and max length can't be negative")
+	}
+	if s.conf.BreachedThreshold < 1 {
+		s.conf.BreachedThreshold = 1
+	}
+	if s.conf.MaxConcurrent < 1 {
+		s.conf.MaxConcurrent = runtime.NumCPU()
+	}
+	s.work = make(chan struct{}, s.conf.MaxConcurrent)
+	s.dummy, err = s.hash(context.Background(), GenerateRandomBase64Bytes(32))
+	if err != nil {
+		return nil, fmt.Errorf("could not create dummy hash: %w", err)
+	}
 	fmt.Printf("	- Using PWD default alg: %d", conf.DefaultAlg)
 	return s, nil
 }
@@ -47,6 +107,10 @@ type wrapper struct {
 	Salt        string `json:"salt"`
 	Alg         Alg    `json:"alg"`
 	AlgMetadata []byte `json:"alg_metadata"`
+	// Pepper is the version of the pepper applied to the password before it was hashed, 0 if none
+	Pepper uint32 `json:"pepper,omitempty"`
+	// History holds the previous passwords, most recent first, used to prevent reuse on change
+	History []wrapper `json:"history,omitempty"`
 }
 
 type shaMetadata struct {
@@ -64,27 +128,166 @@ type scryptMetadata struct {
 	KeyLen int `json:"default_s_crypt_key_len"`
 }
 
+type argon2Metadata struct {
+	Memory      uint32 `json:"memory"`
+	Time        uint32 `json:"time"`
+	Parallelism uint8  `json:"parallelism"`
+	KeyLen      uint32 `json:"key_len"`
+}
+
+// Enroll hashes the password, if it doesn't comply with the policy no userBlob is created and the violations are
+// returned instead
 func (s *Server) Enroll(ctx context.Context, enReq *EnrollReq) (*Blob, error) {
+	violations, err := s.check(ctx, enReq.Password, enReq.Username)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return &Blob{Violations: violations}, nil
+	}
+	o, err := s.hash(ctx, enReq.Password)
+	if err != nil {
+		return nil, err
+	}
+	userBlob, err := s.seal(o)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
+}
+
+// Import wraps a password hash from another system in a userBlob, it is verified in its original format and rehashed
+// using the default algorithm on the first successful auth
+func (s *Server) Import(_ context.Context, req *ImportReq) (*Blob, error) {
+	format, err := legacyFormat(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	metadataBytes, err := json.Marshal(legacyMetadata{Format: format})
+	if err != nil {
+		return nil, err
+	}
+	userBlob, err := s.seal(wrapper{
+		Digest:      req.Hash,
+		Alg:         Alg_Legacy,
+		AlgMetadata: metadataBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
+}
+
+func (s *Server) acquire(ctx context.Context) error {
+	select {
+	case s.work <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) release() {
+	<-s.work
+}
+
+func (s *Server) open(userBlob string) (wrapper, error) {
+	var v wrapper
+	sec, err := s.store.Decrypt(Base64Decode(userBlob))
+	if err != nil {
+		return v, fmt.Errorf("could not decrypt userBlob: %w", err)
+	}
+	err = json.Unmarshal(sec, &v)
+	if err != nil {
+		return v, fmt.Errorf("could not unmarshal userBlob: %w", err)
+	}
+	return v, nil
+}
+
+func (s *Server) seal(o wrapper) (string, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	b, err = s.store.Encrypt(b)
+	if err != nil {
+		return "", err
+	}
+	return Base64Encode(b), nil
+}
+
+// outdated reports whether the wrapper was hashed with another algorithm than the default, or with parameters weaker
+// than the defaults
+func (s *Server) outdated(v wrapper) (bool, error) {
+	if v.Alg != s.conf.DefaultAlg || v.Pepper != s.peppers.max {
+		return true, nil
+	}
+	switch v.Alg {
+	case Alg_SHA_256, Alg_SHA_512:
+		var m shaMetadata
+		err := json.Unmarshal(v.AlgMetadata, &m)
+		if err != nil {
+			return false, err
+		}
+		return m.HashCount < s.conf.DefaultHashCount, nil
+	case Alg_SCrypt:
+		var m scryptMetadata
+		err := json.Unmarshal(v.AlgMetadata, &m)
+		if err != nil {
+			return false, err
+		}
+		return m.N < s.conf.DefaultSCryptN || m.R < s.conf.DefaultSCryptR || m.P < s.conf.DefaultSCryptP || m.KeyLen < s.conf.DefaultSCryptKeyLen, nil
+	case Alg_BCrypt:
+		cost, err := bcrypt.Cost(Base64Decode(v.Digest))
+		if err != nil {
+			return false, err
+		}
+		return cost < s.conf.DefaultBCryptCost, nil
+	case Alg_Argon2id:
+		var m argon2Metadata
+		err := json.Unmarshal(v.AlgMetadata, &m)
+		if err != nil {
+			return false, err
+		}
+		return m.Memory < s.conf.DefaultArgon2Memory || m.Time < s.conf.DefaultArgon2Time ||
+			m.Parallelism < s.conf.DefaultArgon2Parallelism || m.KeyLen < s.conf.DefaultArgon2KeyLen, nil
+	}
+	return false, nil
+}
+
+// hash creates a wrapper for the password using the default algorithm and parameters
+func (s *Server) hash(ctx context.Context, password string) (wrapper, error) {
 	var o wrapper
+	err := s.acquire(ctx)
+	if err != nil {
+		return o, err
+	}
+	defer s.release()
+
 	o.Alg = s.conf.DefaultAlg
+	o.Pepper = s.peppers.max
+	password, err = s.peppers.apply(password, o.Pepper)
+	if err != nil {
+		return o, err
+	}
 
 	switch s.conf.DefaultAlg {
 	case Alg_SHA_256:
 		fallthrough
 	case Alg_SHA_512:
 		o.Salt = GenerateRandomBase64Bytes(32)
-		o.Digest = GetHmacDigest(enReq.Password, o.Salt, Hash(s.conf.DefaultAlg), s.conf.DefaultHashCount)
+		o.Digest = GetHmacDigest(password, o.Salt, Hash(s.conf.DefaultAlg), s.conf.DefaultHashCount)
 		metadataBytes, err := json.Marshal(shaMetadata{HashCount: s.conf.DefaultHashCount})
 		if err != nil {
-			return nil, err
+			return o, err
 		}
 		o.AlgMetadata = metadataBytes
 
 	case Alg_SCrypt:
 		o.Salt = GenerateRandomBase64Bytes(32)
-		dk, err := scrypt.Key([]byte(enReq.Password), []byte(o.Salt), s.conf.DefaultSCryptN, s.conf.DefaultSCryptR, s.conf.DefaultSCryptP, s.conf.DefaultSCryptKeyLen)
+		dk, err := scrypt.Key([]byte(password), []byte(o.Salt), s.conf.DefaultSCryptN, s.conf.DefaultSCryptR, s.conf.DefaultSCryptP, s.conf.DefaultSCryptKeyLen)
 		if err != nil {
-			return nil, err
+			return o, err
 		}
 		o.Digest = Base64Encode(dk)
 		metadataBytes, err := json.Marshal(scryptMetadata{
@@ -94,43 +297,44 @@ func (s *Server) Enroll(ctx context.Context, enReq *EnrollReq) (*Blob, error) {
 			KeyLen: s.conf.DefaultSCryptKeyLen,
 		})
 		if err != nil {
-			return nil, err
+			return o, err
 		}
 		o.AlgMetadata = metadataBytes
 	case Alg_BCrypt:
-		dk, err := bcrypt.GenerateFromPassword([]byte(enReq.Password), s.conf.DefaultBCryptCost)
+		dk, err := bcrypt.GenerateFromPassword([]byte(password), s.conf.DefaultBCryptCost)
 		if err != nil {
-			return nil, err
+			return o, err
 		}
 		o.Digest = Base64Encode(dk)
+	case Alg_Argon2id:
+		o.Salt = GenerateRandomBase64Bytes(32)
+		dk := argon2.IDKey([]byte(password), []byte(o.Salt), s.conf.DefaultArgon2Time, s.conf.DefaultArgon2Memory, s.conf.DefaultArgon2Parallelism, s.conf.DefaultArgon2KeyLen)
+		o.Digest = Base64Encode(dk)
+		metadataBytes, err := json.Marshal(argon2Metadata{
+			Memory:      s.conf.DefaultArgon2Memory,
+			Time:        s.conf.DefaultArgon2Time,
+			Parallelism: s.conf.DefaultArgon2Parallelism,
+			KeyLen:      s.conf.DefaultArgon2KeyLen,
+		})
+		if err != nil {
+			return o, err
+		}
+		o.AlgMetadata = metadataBytes
 	}
-
-	b, err := json.Marshal(o)
-	if err != nil {
-		return nil, err
-	}
-
-	b, err = s.store.Encrypt(b)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Blob{UserBlob: Base64Encode(b)}, nil
+	return o, nil
 }
 
-func (s *Server) Auth(ctx context.Context, authReq *AuthReq) (*Res, error) {
-	sec := Base64Decode(authReq.UserBlob)
-	sec, err := s.store.Decrypt(sec)
+// verify checks the password against the wrapper, using the algorithm, parameters and pepper it was hashed with
+func (s *Server) verify(ctx context.Context, v wrapper, password string) (bool, error) {
+	err := s.acquire(ctx)
 	if err != nil {
-		fmt.Println("decrypt error")
-		return nil, err
+		return false, err
 	}
+	defer s.release()
 
-	var v wrapper
-	err = json.Unmarshal(sec, &v)
+	password, err = s.peppers.apply(password, v.Pepper)
 	if err != nil {
-		fmt.Println("Unmarshal error")
-		return nil, err
+		return false, err
 	}
 
 	var valid bool
@@ -142,32 +346,170 @@ func (s *Server) Auth(ctx context.Context, authReq *AuthReq) (*Res, error) {
 		var _shaMetadata shaMetadata
 		err = json.Unmarshal(v.AlgMetadata, &_shaMetadata)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		newDigest := GetHmacDigest(authReq.Password, v.Salt, Hash(v.Alg), _shaMetadata.HashCount)
+		newDigest := GetHmacDigest(password, v.Salt, Hash(v.Alg), _shaMetadata.HashCount)
 		valid = hmac.Equal(Base64Decode(newDigest), Base64Decode(v.Digest))
 	case Alg_SCrypt:
 		var _scryptMetadata scryptMetadata
 		err = json.Unmarshal(v.AlgMetadata, &_scryptMetadata)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		newDigest, err := scrypt.Key([]byte(authReq.Password), []byte(v.Salt), _scryptMetadata.N, _scryptMetadata.R, _scryptMetadata.P, _scryptMetadata.KeyLen)
+		newDigest, err := scrypt.Key([]byte(password), []byte(v.Salt), _scryptMetadata.N, _scryptMetadata.R, _scryptMetadata.P, _scryptMetadata.KeyLen)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 		valid = hmac.Equal(newDigest, Base64Decode(v.Digest))
 
 	case Alg_BCrypt:
-		err = bcrypt.CompareHashAndPassword(Base64Decode(v.Digest), []byte(authReq.Password))
+		err = bcrypt.CompareHashAndPassword(Base64Decode(v.Digest), []byte(password))
 		if err != nil && err != bcrypt.ErrMismatchedHashAndPassword {
-			return nil, err
+			return false, err
 		}
 		valid = err != bcrypt.ErrMismatchedHashAndPassword
+	case Alg_Legacy:
+		var _legacyMetadata legacyMetadata
+		err = json.Unmarshal(v.AlgMetadata, &_legacyMetadata)
+		if err != nil {
+			return false, err
+		}
+		valid, err = verifyLegacy(_legacyMetadata.Format, v.Digest, password)
+		if err != nil {
+			return false, err
+		}
+	case Alg_Argon2id:
+		var _argon2Metadata argon2Metadata
+		err = json.Unmarshal(v.AlgMetadata, &_argon2Metadata)
+		if err != nil {
+			return false, err
+		}
+		if _argon2Metadata.Time < 1 || _argon2Metadata.Parallelism < 1 || _argon2Metadata.KeyLen < 1 {
+			return false, fmt.Errorf("invalid argon2id parameters")
+		}
+		newDigest := argon2.IDKey([]byte(password), []byte(v.Salt), _argon2Metadata.Time, _argon2Metadata.Memory, _argon2Metadata.Parallelism, _argon2Metadata.KeyLen)
+		valid = hmac.Equal(newDigest, Base64Decode(v.Digest))
+	}
+	return valid, nil
+}
+
+// Auth verifies the password against the userBlob. If the user doesn't exist, pass an empty userBlob and the password
+// is verified against a dummy hash with the default algorithm and parameters, so that the response time doesn't reveal
+// whether the user exists. It's always invalid
+func (s *Server) Auth(ctx context.Context, authReq *AuthReq) (*Res, error) {
+	if authReq.UserBlob == "" {
+		_, err := s.verify(ctx, s.dummy, authReq.Password)
+		if err != nil {
+			return nil, err
+		}
+		return &Res{Valid: false, Message: "password processed"}, nil
+	}
+
+	v, err := s.open(authReq.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := s.verify(ctx, v, authReq.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Res{Valid: valid, Message: "password processed"}
+	if !valid {
+		return res, nil
 	}
 
-	return &Res{Valid: valid, Message: "password processed"}, nil
+	// The password is only known here, so this is the only chance to bring a weak blob up to the current defaults
+	outdated, err := s.outdated(v)
+	if err != nil {
+		return nil, err
+	}
+	if outdated {
+		o, err := s.hash(ctx, authReq.Password)
+		if err != nil {
+			return nil, err
+		}
+		o.History = s.checkable(v.History)
+		res.UserBlob, err = s.seal(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Change verifies the old password and replaces it with the new one, which must comply with the policy and may not be
+// one of the last HistorySize passwords
+func (s *Server) Change(ctx context.Context, req *ChangeReq) (*ChangeRes, error) {
+	v, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := s.verify(ctx, v, req.OldPassword)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return &ChangeRes{Message: "old password is not valid"}, nil
+	}
 
+	res := &ChangeRes{Valid: true}
+	res.Violations, err = s.check(ctx, req.NewPassword, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// The current password is the most recent one in the history, it's always checked
+	size := max(s.conf.HistorySize, 1)
+	previous := v
+	previous.History = nil
+	history := append([]wrapper{previous}, s.checkable(v.History)...)
+	history = history[:min(len(history), size)]
+	for _, h := range history {
+		reused, err := s.verify(ctx, h, req.NewPassword)
+		if err != nil {
+			return nil, err
+		}
+		if reused {
+			res.Violations = append(res.Violations, Violation{
+				Code:    ViolationReused,
+				Message: fmt.Sprintf("password may not be one of the last %d passwords", size),
+			})
+			break
+		}
+	}
+	if len(res.Violations) > 0 {
+		res.Message = "new password does not comply with the policy"
+		return res, nil
+	}
+
+	o, err := s.hash(ctx, req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	if size > 1 {
+		o.History = history[:min(len(history), size-1)]
+	}
+	res.UserBlob, err = s.seal(o)
+	if err != nil {
+		return nil, err
+	}
+	res.Message = "password changed"
+	return res, nil
+}
+
+// checkable returns the history without the passwords peppered with a version that has been removed, they can no
+// longer be checked for reuse and would otherwise make every change fail
+func (s *Server) checkable(history []wrapper) []wrapper {
+	var kept []wrapper
+	for _, h := range history {
+		if s.peppers.has(h.Pepper) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
 }
 
 func (s *Server) Upgrade(_ context.Context, req *Blob) (*Blob, error) {
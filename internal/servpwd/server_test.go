@@ -0,0 +1,423 @@
+package servpwd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArgon2id(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(PWDConfig{
+		DefaultAlg:               Alg_Argon2id,
+		DefaultArgon2Memory:      1024,
+		DefaultArgon2Time:        1,
+		DefaultArgon2Parallelism: 1,
+		DefaultArgon2KeyLen:      32,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := s.Enroll(ctx, &EnrollReq{Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected password to be valid")
+	}
+	res, err = s.Auth(ctx, &AuthReq{Password: "wrong horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected password to be invalid")
+	}
+
+	// A server with other defaults must still verify blobs using the parameters they were created with
+	other, err := New(PWDConfig{
+		DefaultAlg:               Alg_Argon2id,
+		DefaultArgon2Memory:      2048,
+		DefaultArgon2Time:        2,
+		DefaultArgon2Parallelism: 2,
+		DefaultArgon2KeyLen:      16,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = other.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatal("expected password to be valid with other default parameters")
+	}
+
+	var v wrapper
+	err = json.Unmarshal(Base64Decode(blob.UserBlob), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m argon2Metadata
+	err = json.Unmarshal(v.AlgMetadata, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (argon2Metadata{Memory: 1024, Time: 1, Parallelism: 1, KeyLen: 32}) {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+}
+
+func TestRehashOnAuth(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="}
+	old, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := old.Enroll(ctx, &EnrollReq{Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := old.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob != "" {
+		t.Fatal("expected no rehash when the blob matches the defaults")
+	}
+
+	s, err := New(PWDConfig{DefaultAlg: Alg_BCrypt, DefaultBCryptCost: 4}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.Auth(ctx, &AuthReq{Password: "wrong horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.UserBlob != "" {
+		t.Fatal("expected no rehash on an invalid password")
+	}
+	res, err = s.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob == "" {
+		t.Fatal("expected a rehashed blob when the algorithm has changed")
+	}
+	rehashed := res.UserBlob
+
+	// Raising the cost makes the bcrypt blob outdated as well
+	s.conf.DefaultBCryptCost = 5
+	res, err = s.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: rehashed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob == "" {
+		t.Fatal("expected a rehashed blob when the cost has been raised")
+	}
+	res, err = s.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob != "" {
+		t.Fatal("expected no rehash once the blob is up to date")
+	}
+}
+
+func TestPepper(t *testing.T) {
+	ctx := context.Background()
+	plain, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := plain.Enroll(ctx, &EnrollReq{Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pepper1 := "1:cGVwcGVyIG51bWJlciBvbmUgMTIzNDU2"
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, Peppers: []string{pepper1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob == "" {
+		t.Fatal("expected a blob without pepper to be valid and rehashed")
+	}
+	var v wrapper
+	err = json.Unmarshal(Base64Decode(res.UserBlob), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Pepper != 1 || v.Digest == GetHmacDigest("correct horse", v.Salt, Hash(Alg_SHA_256), 1) {
+		t.Fatalf("expected digest to be peppered with version 1, got version %d", v.Pepper)
+	}
+	peppered := res.UserBlob
+
+	// Rotating the pepper, version 1 is kept to verify existing blobs
+	rotated, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, Peppers: []string{pepper1, "2:cGVwcGVyIG51bWJlciB0d28gMTIzNDU2"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = rotated.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: peppered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob == "" {
+		t.Fatal("expected a blob with an old pepper to be valid and rehashed")
+	}
+	res, err = rotated.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob != "" {
+		t.Fatal("expected a blob with the latest pepper to be valid and up to date")
+	}
+
+	// Without the pepper the blob can't be verified
+	_, err = plain.Auth(ctx, &AuthReq{Password: "correct horse", UserBlob: peppered})
+	if err == nil {
+		t.Fatal("expected an error when the pepper is not configured")
+	}
+
+	for _, p := range [][]string{{"cGVwcGVy"}, {"0:cGVwcGVyIG51bWJlciBvbmUgMTIzNDU2"}, {"1:short"}, {pepper1, pepper1}} {
+		_, err = New(PWDConfig{Peppers: p}, nil)
+		if err == nil {
+			t.Fatalf("expected peppers %v to be rejected", p)
+		}
+	}
+}
+
+func TestChange(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, MinLength: 8, HistorySize: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := s.Enroll(ctx, &EnrollReq{Password: "first password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Change(ctx, &ChangeReq{OldPassword: "wrong password", NewPassword: "second password", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.UserBlob != "" {
+		t.Fatal("expected change to be rejected with a wrong old password")
+	}
+
+	res, err = s.Change(ctx, &ChangeReq{OldPassword: "first password", NewPassword: "short", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob != "" || !reflect.DeepEqual(codes(res.Violations), []string{ViolationTooShort}) {
+		t.Fatalf("expected the policy to be applied, got %v", codes(res.Violations))
+	}
+
+	userBlob := blob.UserBlob
+	passwords := []string{"first password", "second password", "third password", "fourth password"}
+	for i := 1; i < len(passwords); i++ {
+		res, err = s.Change(ctx, &ChangeReq{OldPassword: passwords[i-1], NewPassword: passwords[i], UserBlob: userBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Valid || res.UserBlob == "" {
+			t.Fatalf("expected change to %q to succeed, got %v", passwords[i], codes(res.Violations))
+		}
+		userBlob = res.UserBlob
+	}
+
+	// The last 3 passwords, including the current one, can't be reused
+	for _, p := range passwords[1:] {
+		res, err = s.Change(ctx, &ChangeReq{OldPassword: "fourth password", NewPassword: p, UserBlob: userBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UserBlob != "" || !reflect.DeepEqual(codes(res.Violations), []string{ViolationReused}) {
+			t.Fatalf("expected %q to be rejected as reused, got %v", p, codes(res.Violations))
+		}
+	}
+	res, err = s.Change(ctx, &ChangeReq{OldPassword: "fourth password", NewPassword: "first password", UserBlob: userBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UserBlob == "" {
+		t.Fatalf("expected a password older than the history to be accepted, got %v", codes(res.Violations))
+	}
+
+	auth, err := s.Auth(ctx, &AuthReq{Password: "first password", UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.Valid {
+		t.Fatal("expected the new password to be valid")
+	}
+	v, err := s.open(res.UserBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.History) != 2 {
+		t.Fatalf("expected 2 passwords in history, got %d", len(v.History))
+	}
+}
+
+func TestChangeHistorySize(t *testing.T) {
+	ctx := context.Background()
+	_, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, HistorySize: -1}, nil)
+	if err == nil {
+		t.Fatal("expected a negative history size to be rejected")
+	}
+
+	// The current password can't be reused, no matter the history size, but no earlier passwords are kept
+	for _, size := range []int{0, 1} {
+		s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, HistorySize: size}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := s.Enroll(ctx, &EnrollReq{Password: "first password"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := s.Change(ctx, &ChangeReq{OldPassword: "first password", NewPassword: "first password", UserBlob: blob.UserBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UserBlob != "" || !reflect.DeepEqual(codes(res.Violations), []string{ViolationReused}) {
+			t.Fatalf("size %d: expected the current password to be rejected as reused, got %v", size, codes(res.Violations))
+		}
+
+		res, err = s.Change(ctx, &ChangeReq{OldPassword: "first password", NewPassword: "second password", UserBlob: blob.UserBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UserBlob == "" {
+			t.Fatalf("size %d: expected change to succeed, got %v", size, codes(res.Violations))
+		}
+		v, err := s.open(res.UserBlob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v.History) != 0 {
+			t.Fatalf("size %d: expected no passwords in history, got %d", size, len(v.History))
+		}
+
+		res, err = s.Change(ctx, &ChangeReq{OldPassword: "second password", NewPassword: "first password", UserBlob: res.UserBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UserBlob == "" {
+			t.Fatalf("size %d: expected the previous password to be accepted, got %v", size, codes(res.Violations))
+		}
+	}
+}
+
+func TestChangeRotatedPepper(t *testing.T) {
+	ctx := context.Background()
+	pepper1 := "1:cGVwcGVyIG51bWJlciBvbmUgMTIzNDU2"
+	pepper2 := "2:cGVwcGVyIG51bWJlciB0d28gMTIzNDU2"
+	s, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, HistorySize: 3, Peppers: []string{pepper1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := s.Enroll(ctx, &EnrollReq{Password: "first password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Change(ctx, &ChangeReq{OldPassword: "first password", NewPassword: "second password", UserBlob: blob.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The current password is rehashed with pepper 2 on auth, while the history is left with pepper 1
+	rotated, err := New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, HistorySize: 3, Peppers: []string{pepper1, pepper2}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := rotated.Auth(ctx, &AuthReq{Password: "second password", UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.Valid || auth.UserBlob == "" {
+		t.Fatal("expected the blob to be rehashed with the new pepper")
+	}
+
+	// Once pepper 1 is removed, the history peppered with it can't be checked and is dropped
+	s, err = New(PWDConfig{DefaultAlg: Alg_SHA_256, DefaultHashCount: 1, HistorySize: 3, Peppers: []string{pepper2}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.Change(ctx, &ChangeReq{OldPassword: "second password", NewPassword: "first password", UserBlob: auth.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.UserBlob == "" {
+		t.Fatalf("expected change to succeed, got %v", codes(res.Violations))
+	}
+	v, err := s.open(res.UserBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.History) != 1 || v.History[0].Pepper != 2 {
+		t.Fatalf("expected only the password peppered with version 2 in history, got %d", len(v.History))
+	}
+
+	// The passwords that are still in the history can't be reused
+	res, err = s.Change(ctx, &ChangeReq{OldPassword: "first password", NewPassword: "second password", UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UserBlob != "" || !reflect.DeepEqual(codes(res.Violations), []string{ViolationReused}) {
+		t.Fatalf("expected the password to be rejected as reused, got %v", codes(res.Violations))
+	}
+}
+
+func TestAuthUnknownUser(t *testing.T) {
+	s, err := New(PWDConfig{DefaultAlg: Alg_BCrypt, DefaultBCryptCost: 4, MaxConcurrent: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.dummy.Alg != Alg_BCrypt || s.dummy.Digest == "" {
+		t.Fatal("expected a dummy hash with the default algorithm")
+	}
+
+	res, err := s.Auth(context.Background(), &AuthReq{Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.UserBlob != "" {
+		t.Fatal("expected an unknown user to be invalid")
+	}
+
+	// With the only slot taken, requests wait until their context is done
+	err = s.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = s.Auth(ctx, &AuthReq{Password: "correct horse"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected request to time out waiting for a slot, got %v", err)
+	}
+	s.release()
+
+	_, err = s.Auth(context.Background(), &AuthReq{Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
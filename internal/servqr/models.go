@@ -26,10 +26,27 @@ var Data_Recovery_value = map[string]int32{
 	"HIGHEST": 3,
 }
 
+type Format int32
+
+const (
+	Format_PNG Format = 0
+	Format_SVG Format = 1
+)
+
+var Format_name = map[int32]string{
+	0: "PNG",
+	1: "SVG",
+}
+var Format_value = map[string]int32{
+	"PNG": 0,
+	"SVG": 1,
+}
+
 type Data struct {
 	RecoveryLevel Data_Recovery `json:"RecoveryLevel,omitempty"`
 	Size          int32         `json:"size,omitempty"`
 	Data          string        `json:"data,omitempty"`
+	Format        Format        `json:"format,omitempty"`
 }
 
 func (m *Data) GetRecoveryLevel() Data_Recovery {
@@ -53,6 +70,13 @@ func (m *Data) GetData() string {
 	return ""
 }
 
+func (m *Data) GetFormat() Format {
+	if m != nil {
+		return m.Format
+	}
+	return Format_PNG
+}
+
 type Image struct {
 	ContentType string `json:"contentType,omitempty"`
 	Data        []byte `json:"data,omitempty"`
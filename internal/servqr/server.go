@@ -1,6 +1,10 @@
 package servqr
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	qrcode "github.com/skip2/go-qrcode"
 	"golang.org/x/net/context"
 )
@@ -13,6 +17,11 @@ type Server struct {
 }
 
 func (s Server) Generate(ctx context.Context, data *Data) (*Image, error) {
+	return Encode(data)
+}
+
+// Encode renders data as a QR code image, in the requested format. Sizes below 10 pixels defaults to 256
+func Encode(data *Data) (*Image, error) {
 	size := int(data.Size)
 
 	if size < 10 {
@@ -20,10 +29,41 @@ func (s Server) Generate(ctx context.Context, data *Data) (*Image, error) {
 	}
 
 	level := qrcode.RecoveryLevel(data.RecoveryLevel)
-	image, err := qrcode.Encode(data.Data, level, size)
 
-	return &Image{
-		Data:        image,
-		ContentType: "image/png",
-	}, err
+	switch data.Format {
+	case Format_PNG:
+		image, err := qrcode.Encode(data.Data, level, size)
+		return &Image{
+			Data:        image,
+			ContentType: "image/png",
+		}, err
+	case Format_SVG:
+		q, err := qrcode.New(data.Data, level)
+		if err != nil {
+			return nil, err
+		}
+		return &Image{
+			Data:        svg(q.Bitmap(), size),
+			ContentType: "image/svg+xml",
+		}, nil
+	default:
+		return nil, errors.New("format must be PNG or SVG")
+	}
+}
+
+// svg renders the bitmap as a single path, with one unit per module, scaled to size
+func svg(bitmap [][]bool, size int) []byte {
+	n := len(bitmap)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, set := range row {
+			if set {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	sb.WriteString(`"/></svg>`)
+	return []byte(sb.String())
 }
@@ -0,0 +1,91 @@
+package servrecovery
+
+type EnrollReq struct {
+	// Count is the number of recovery codes to generate, defaults to the configured count
+	Count uint32 `json:"count,omitempty"`
+}
+
+func (m *EnrollReq) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+type EnrollRes struct {
+	// Codes are the recovery codes in plaintext, they are only returned once and shall be shown to the user
+	Codes    []string `json:"codes,omitempty"`
+	UserBlob string   `json:"userBlob,omitempty"`
+}
+
+func (m *EnrollRes) GetCodes() []string {
+	if m != nil {
+		return m.Codes
+	}
+	return nil
+}
+
+func (m *EnrollRes) GetUserBlob() string {
+	if m != nil {
+		return m.UserBlob
+	}
+	return ""
+}
+
+type AuthReq struct {
+	Code     string `json:"code,omitempty"`
+	UserBlob string `json:"userBlob,omitempty"`
+}
+
+func (m *AuthReq) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *AuthReq) GetUserBlob() string {
+	if m != nil {
+		return m.UserBlob
+	}
+	return ""
+}
+
+type AuthRes struct {
+	Valid bool `json:"valid,omitempty"`
+	// Remaining is the number of unused recovery codes left in the blob
+	Remaining uint32 `json:"remaining"`
+	UserBlob  string `json:"userBlob,omitempty"`
+}
+
+func (m *AuthRes) GetValid() bool {
+	if m != nil {
+		return m.Valid
+	}
+	return false
+}
+
+func (m *AuthRes) GetRemaining() uint32 {
+	if m != nil {
+		return m.Remaining
+	}
+	return 0
+}
+
+func (m *AuthRes) GetUserBlob() string {
+	if m != nil {
+		return m.UserBlob
+	}
+	return ""
+}
+
+type Blob struct {
+	UserBlob string `json:"userBlob,omitempty"`
+}
+
+func (m *Blob) GetUserBlob() string {
+	if m != nil {
+		return m.UserBlob
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package servrecovery
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/modfin/twofer/internal/crypt"
+	"github.com/modfin/twofer/internal/ratelimit"
+)
+
+// alphabet used for recovery codes, ambiguous characters such as 0/o and 1/l/i are left out
+const alphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+const maxCount = 100
+
+type RecoveryConfig struct {
+	// Count is the default number of codes generated on enroll
+	Count uint32
+	// Length is the number of characters in each code, not counting separators
+	Length    uint32
+	RateLimit uint
+}
+
+type Server struct {
+	store       crypt.Store
+	conf        RecoveryConfig
+	ratelimiter *ratelimit.Ratelimiter
+}
+
+func New(conf RecoveryConfig, keys []string) (*Server, error) {
+	s := &Server{conf: conf}
+	var err error
+	if len(keys) > 0 {
+		s.store, err = crypt.New(keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.store == nil {
+		s.store = &crypt.NilStore{}
+	}
+
+	if s.conf.Count == 0 {
+		s.conf.Count = 10
+	}
+	if s.conf.Length < 8 {
+		s.conf.Length = 10
+	}
+	if s.conf.RateLimit == 0 {
+		s.conf.RateLimit = 10
+	}
+
+	s.ratelimiter = ratelimit.New(s.conf.RateLimit)
+
+	return s, nil
+}
+
+type wrapper struct {
+	Salt string `json:"salt"`
+	// Codes holds the base64 encoded sha256 digest of each unused code
+	Codes []string `json:"codes"`
+}
+
+func (s *Server) open(userBlob string) (wrapper, error) {
+	var v wrapper
+	sec, err := base64.StdEncoding.DecodeString(userBlob)
+	if err != nil {
+		return v, err
+	}
+	sec, err = s.store.Decrypt(sec)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(sec, &v)
+	return v, err
+}
+
+func (s *Server) seal(v wrapper) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	b, err = s.store.Encrypt(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (s *Server) Enroll(_ context.Context, req *EnrollReq) (*EnrollRes, error) {
+	count := req.GetCount()
+	if count == 0 {
+		count = s.conf.Count
+	}
+	if count > maxCount {
+		return nil, errors.New("too many recovery codes requested")
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	v := wrapper{Salt: base64.StdEncoding.EncodeToString(salt)}
+	res := &EnrollRes{}
+	for i := uint32(0); i < count; i++ {
+		code, err := generateCode(int(s.conf.Length))
+		if err != nil {
+			return nil, err
+		}
+		res.Codes = append(res.Codes, code)
+		v.Codes = append(v.Codes, digest(v.Salt, code))
+	}
+
+	res.UserBlob, err = s.seal(v)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Auth consumes the code if it matches any of the unused codes in the blob, the returned blob must be persisted
+// since a consumed code can not be used again
+func (s *Server) Auth(_ context.Context, req *AuthReq) (*AuthRes, error) {
+	v, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ratelimiter.Hit(v.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	d := digest(v.Salt, req.Code)
+	match := -1
+	for i, c := range v.Codes {
+		// Comparing all codes, to not leak the position of a matching code through timing
+		if subtle.ConstantTimeCompare([]byte(c), []byte(d)) == 1 {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return &AuthRes{Valid: false, Remaining: uint32(len(v.Codes)), UserBlob: req.UserBlob}, nil
+	}
+
+	v.Codes = append(v.Codes[:match], v.Codes[match+1:]...)
+	userBlob, err := s.seal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthRes{Valid: true, Remaining: uint32(len(v.Codes)), UserBlob: userBlob}, nil
+}
+
+func (s *Server) Upgrade(_ context.Context, req *Blob) (*Blob, error) {
+	v, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+	userBlob, err := s.seal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
+}
+
+func generateCode(length int) (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(alphabet)))
+	for i := 0; i < length; i++ {
+		if i > 0 && i%5 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(alphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// normalize removes separators and white space, and lower cases the code, so that it is easier for users to type
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
+
+func digest(salt string, code string) string {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(normalize(code)))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package servrecovery
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEnrollAuth(t *testing.T) {
+	s, err := New(RecoveryConfig{RateLimit: 100}, []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w=="})
+	if err != nil {
+		t.Fatalf("New(...) returned error: %v", err)
+	}
+	ctx := context.Background()
+
+	en, err := s.Enroll(ctx, &EnrollReq{Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(en.Codes) != 3 {
+		t.Fatalf("expected 3 codes, got %d", len(en.Codes))
+	}
+
+	res, err := s.Auth(ctx, &AuthReq{Code: "aaaaa-aaaaa", UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Remaining != 3 {
+		t.Fatalf("expected invalid code with 3 remaining, got valid=%v remaining=%d", res.Valid, res.Remaining)
+	}
+
+	// Codes are accepted regardless of case and separators
+	code := strings.ToUpper(strings.ReplaceAll(en.Codes[1], "-", " "))
+	res, err = s.Auth(ctx, &AuthReq{Code: code, UserBlob: en.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || res.Remaining != 2 {
+		t.Fatalf("expected valid code with 2 remaining, got valid=%v remaining=%d", res.Valid, res.Remaining)
+	}
+
+	res, err = s.Auth(ctx, &AuthReq{Code: en.Codes[1], UserBlob: res.UserBlob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected a consumed code to be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+package servw6n
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found in userBlob")
+
+// Credentials lists the credentials in the userBlob, so that the user can tell them apart and eg. remove a lost key
+func (s *Server) Credentials(_ context.Context, req *Blob) (*CredentialsRes, error) {
+	u, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &CredentialsRes{Credentials: []CredentialSummary{}}
+	for _, c := range u.Credentials {
+		summary := CredentialSummary{
+			ID:        c.ID,
+			RPID:      c.RPID,
+			Nickname:  c.Nickname,
+			Created:   c.Created,
+			LastUsed:  c.LastUsed,
+			PRF:       c.PRF,
+			LargeBlob: c.LargeBlob,
+		}
+		if aaguid, err := uuid.FromBytes(c.Authenticator.AAGUID); err == nil {
+			summary.AAGUID = aaguid.String()
+		}
+		for _, t := range c.Transport {
+			summary.Transports = append(summary.Transports, string(t))
+		}
+		res.Credentials = append(res.Credentials, summary)
+	}
+	return res, nil
+}
+
+// RenameCredential sets the nickname of a credential and returns the new userBlob
+func (s *Server) RenameCredential(_ context.Context, req *RenameReq) (*Blob, error) {
+	u, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	i := u.credential(req.ID)
+	if i < 0 {
+		return nil, ErrCredentialNotFound
+	}
+	u.Credentials[i].Nickname = req.Nickname
+
+	userBlob, err := s.seal(u)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
+}
+
+// RemoveCredential removes a credential, eg. a lost key, and returns the new userBlob
+func (s *Server) RemoveCredential(_ context.Context, req *RemoveReq) (*Blob, error) {
+	u, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	i := u.credential(req.ID)
+	if i < 0 {
+		return nil, ErrCredentialNotFound
+	}
+	u.Credentials = slices.Delete(u.Credentials, i, i+1)
+
+	userBlob, err := s.seal(u)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
+}
+
+// credential returns the index of the credential with the id, or -1 if the user doesn't have it
+func (u User) credential(id []byte) int {
+	if len(id) == 0 {
+		return -1
+	}
+	return slices.IndexFunc(u.Credentials, func(c Credential) bool {
+		return bytes.Equal(c.ID, id)
+	})
+}
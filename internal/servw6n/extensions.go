@@ -0,0 +1,148 @@
+package servw6n
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+const (
+	extensionPRF       = "prf"
+	extensionLargeBlob = "largeBlob"
+)
+
+// registration returns the extensions for the credential creation options, the binary values are base64url encoded as
+// in the WebAuthn JSON serialization
+func (e *Extensions) registration() (protocol.AuthenticationExtensions, error) {
+	if e == nil {
+		return nil, nil
+	}
+	ext := protocol.AuthenticationExtensions{}
+	if e.PRF != nil {
+		if len(e.PRF.EvalByCredential) > 0 {
+			return nil, errors.New("prf evalByCredential can only be used on auth")
+		}
+		ext[extensionPRF] = e.PRF.json()
+	}
+	if e.LargeBlob != nil {
+		if e.LargeBlob.Read || len(e.LargeBlob.Write) > 0 {
+			return nil, errors.New("largeBlob read and write can only be used on auth")
+		}
+		if e.LargeBlob.Support != "required" && e.LargeBlob.Support != "preferred" {
+			return nil, errors.New("largeBlob support must be required or preferred")
+		}
+		ext[extensionLargeBlob] = largeBlobInputsJSON{Support: e.LargeBlob.Support}
+	}
+	return ext, nil
+}
+
+// assertion returns the extensions for the credential request options
+func (e *Extensions) assertion() (protocol.AuthenticationExtensions, error) {
+	if e == nil {
+		return nil, nil
+	}
+	ext := protocol.AuthenticationExtensions{}
+	if e.PRF != nil {
+		ext[extensionPRF] = e.PRF.json()
+	}
+	if e.LargeBlob != nil {
+		if e.LargeBlob.Support != "" {
+			return nil, errors.New("largeBlob support can only be used on enroll")
+		}
+		if e.LargeBlob.Read == (len(e.LargeBlob.Write) > 0) {
+			return nil, errors.New("largeBlob must either read or write")
+		}
+		ext[extensionLargeBlob] = largeBlobInputsJSON{Read: e.LargeBlob.Read, Write: e.LargeBlob.Write}
+	}
+	return ext, nil
+}
+
+type prfValuesJSON struct {
+	First  protocol.URLEncodedBase64 `json:"first"`
+	Second protocol.URLEncodedBase64 `json:"second,omitempty"`
+}
+
+type prfInputsJSON struct {
+	Eval             *prfValuesJSON           `json:"eval,omitempty"`
+	EvalByCredential map[string]prfValuesJSON `json:"evalByCredential,omitempty"`
+}
+
+func (v PRFValues) json() prfValuesJSON {
+	return prfValuesJSON{First: v.First, Second: v.Second}
+}
+
+func (p *PRFInputs) json() prfInputsJSON {
+	var j prfInputsJSON
+	if p.Eval != nil {
+		eval := p.Eval.json()
+		j.Eval = &eval
+	}
+	for id, v := range p.EvalByCredential {
+		if j.EvalByCredential == nil {
+			j.EvalByCredential = map[string]prfValuesJSON{}
+		}
+		j.EvalByCredential[id] = v.json()
+	}
+	return j
+}
+
+type largeBlobInputsJSON struct {
+	Support string                    `json:"support,omitempty"`
+	Read    bool                      `json:"read,omitempty"`
+	Write   protocol.URLEncodedBase64 `json:"write,omitempty"`
+}
+
+type extensionOutputsJSON struct {
+	PRF *struct {
+		Enabled *bool          `json:"enabled,omitempty"`
+		Results *prfValuesJSON `json:"results,omitempty"`
+	} `json:"prf,omitempty"`
+	LargeBlob *struct {
+		Supported *bool                     `json:"supported,omitempty"`
+		Blob      protocol.URLEncodedBase64 `json:"blob,omitempty"`
+		Written   *bool                     `json:"written,omitempty"`
+	} `json:"largeBlob,omitempty"`
+}
+
+// extensionOutputs returns the client extension results of the prf and largeBlob extensions, or nil if there are none
+func extensionOutputs(results protocol.AuthenticationExtensionsClientOutputs) (*ExtensionOutputs, error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	var j extensionOutputsJSON
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, errors.New("failed to parse client extension results")
+	}
+	if j.PRF == nil && j.LargeBlob == nil {
+		return nil, nil
+	}
+
+	out := &ExtensionOutputs{}
+	if j.PRF != nil {
+		out.PRF = &PRFOutputs{}
+		if j.PRF.Enabled != nil {
+			out.PRF.Enabled = *j.PRF.Enabled
+		}
+		if j.PRF.Results != nil {
+			// Results are only returned by an authenticator that supports prf, even if enabled isn't
+			out.PRF.Enabled = true
+			out.PRF.Results = &PRFValues{First: j.PRF.Results.First, Second: j.PRF.Results.Second}
+		}
+	}
+	if j.LargeBlob != nil {
+		out.LargeBlob = &LargeBlobOutputs{Blob: j.LargeBlob.Blob}
+		if j.LargeBlob.Supported != nil {
+			out.LargeBlob.Supported = *j.LargeBlob.Supported
+		}
+		if j.LargeBlob.Written != nil {
+			out.LargeBlob.Written = *j.LargeBlob.Written
+		}
+	}
+	return out, nil
+}
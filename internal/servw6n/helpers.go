@@ -7,8 +7,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/modfin/twofer/internal/crypt"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -26,16 +31,120 @@ func toUserVerification(mode string) protocol.UserVerificationRequirement {
 
 }
 
+func timeouts(timeout time.Duration) webauthn.TimeoutsConfig {
+	t := webauthn.TimeoutConfig{Enforce: true, Timeout: timeout, TimeoutUVD: timeout}
+	return webauthn.TimeoutsConfig{Login: t, Registration: t}
+}
+
+func toAuthenticatorSelection(userVerification string, residentKey string) protocol.AuthenticatorSelection {
+	selection := protocol.AuthenticatorSelection{
+		UserVerification: toUserVerification(userVerification),
+	}
+	switch residentKey {
+	case "required":
+		selection.ResidentKey = protocol.ResidentKeyRequirementRequired
+		selection.RequireResidentKey = protocol.ResidentKeyRequired()
+	case "preferred":
+		selection.ResidentKey = protocol.ResidentKeyRequirementPreferred
+	case "discouraged":
+		selection.ResidentKey = protocol.ResidentKeyRequirementDiscouraged
+	}
+	return selection
+}
+
+const androidOrigin = "android:apk-key-hash:"
+
+// origins returns the allowed origins of the RP. Web origins must use https, except for localhost, and be the RP ID or
+// a subdomain of it. Android apps sign with origins in the form "android:apk-key-hash:<base64url sha256 of the cert>"
+func origins(rpID string, origin string, others []string) ([]string, error) {
+	var allowed []string
+	for _, o := range append([]string{origin}, others...) {
+		if o == "" {
+			continue
+		}
+		if strings.HasPrefix(o, androidOrigin) {
+			if strings.TrimPrefix(o, androidOrigin) == "" {
+				return nil, fmt.Errorf("origin %q is missing the apk key hash", o)
+			}
+			allowed = append(allowed, o)
+			continue
+		}
+		u, err := webOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		if u.Hostname() != rpID && !strings.HasSuffix(u.Hostname(), "."+rpID) {
+			return nil, fmt.Errorf("origin %q is not within the rp id %q", o, rpID)
+		}
+		allowed = append(allowed, u.String())
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("at least one origin must be provided")
+	}
+	slices.Sort(allowed)
+	return slices.Compact(allowed), nil
+}
+
+// topOrigins returns the allowed top origins, these are other sites and are not bound to the RP ID
+func topOrigins(others []string) ([]string, error) {
+	var allowed []string
+	for _, o := range others {
+		u, err := webOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, u.String())
+	}
+	return allowed, nil
+}
+
+func webOrigin(origin string) (*url.URL, error) {
+	fq, err := protocol.FullyQualifiedOrigin(origin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	u, err := url.Parse(fq)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	localhost := u.Hostname() == "localhost" || strings.HasSuffix(u.Hostname(), ".localhost")
+	if u.Scheme != "https" && !(u.Scheme == "http" && localhost) {
+		return nil, fmt.Errorf("origin %q must use https", origin)
+	}
+	return u, nil
+}
+
 type User struct {
 	Id          string       `json:"id"`
 	Name        string       `json:"name"`
 	DisplayName string       `json:"display_name"`
-	Credentials []Credential `json:"credentials, omitempty"`
+	Credentials []Credential `json:"credentials,omitempty"`
 }
 
 type Credential struct {
 	webauthn.Credential
 	RPID string
+	// Nickname is a name for the credential given by the user, eg. "Yubikey on keychain"
+	Nickname string `json:"nickname,omitempty"`
+	// Created is the unix time of the enrollment of the credential
+	Created int64 `json:"created,omitempty"`
+	// LastUsed is the unix time of the last successful login with the credential
+	LastUsed int64 `json:"lastUsed,omitempty"`
+	// PRF and LargeBlob are set when the authenticator has reported support for the extensions with the credential
+	PRF       bool `json:"prf,omitempty"`
+	LargeBlob bool `json:"largeBlob,omitempty"`
+}
+
+// forRP returns the user with only the credentials that are valid for the RP
+func (u User) forRP(rpID string) User {
+	var credentials []Credential
+	for _, c := range u.Credentials {
+		if c.RPID == rpID {
+			credentials = append(credentials, c)
+		}
+	}
+	u.Credentials = credentials
+	return u
 }
 
 func (u User) WebAuthnID() []byte {
@@ -66,42 +175,60 @@ func (u User) WebAuthnCredentials() []webauthn.Credential {
 	return cred
 }
 
+var ErrSessionUsed = errors.New("session has already been used")
+
+// Purposes of a session, a session can only be finalized by the call matching the init that created it
+const (
+	PurposeEnroll = "enroll"
+	PurposeAuth   = "auth"
+)
+
 type Session struct {
 	Deadline time.Time             `json:"deadline"`
+	Purpose  string                `json:"purpose"`
 	Data     *webauthn.SessionData `json:"data"`
 	User     User                  `json:"user"`
+	// Discoverable is set for usernameless logins, where the user is resolved from the user handle on AuthFinal
+	Discoverable bool `json:"discoverable,omitempty"`
 }
 
-func (s Session) Marshal(key []byte) ([]byte, error) {
+// Marshal encrypts the session, since it holds the challenge and the credentials of the user, and signs the ciphertext
+func (s Session) Marshal(key []byte, store crypt.Store) ([]byte, error) {
 	j, err := json.Marshal(s)
 	if err != nil {
 		return nil, err
 	}
+	ciphertext, err := store.Encrypt(j)
+	if err != nil {
+		return nil, err
+	}
 	mac := hmac.New(sha256.New, key)
-	_, err = mac.Write(j)
+	_, err = mac.Write(ciphertext)
 	if err != nil {
 		return nil, err
 	}
 	sig := mac.Sum(nil)
-	return []byte(base64.StdEncoding.EncodeToString(j) + "." + base64.StdEncoding.EncodeToString(sig)), nil
+	return []byte(base64.StdEncoding.EncodeToString(ciphertext) + "." + base64.StdEncoding.EncodeToString(sig)), nil
 }
 
-func (s *Session) Unmarshal(key []byte, token []byte) error {
+func (s *Session) Unmarshal(key []byte, store crypt.Store, token []byte, purpose string) error {
 
 	parts := bytes.Split(token, []byte("."))
 	if len(parts) != 2 {
 		return errors.New("not a correct formatted token")
 	}
 
-	payload := parts[0]
 	sig1, err := base64.StdEncoding.DecodeString(string(parts[1]))
 	if err != nil {
 		return err
 	}
-	j, err := base64.StdEncoding.DecodeString(string(payload))
+	ciphertext, err := base64.StdEncoding.DecodeString(string(parts[0]))
+	if err != nil {
+		return err
+	}
 
 	mac := hmac.New(sha256.New, key)
-	_, err = mac.Write(j)
+	_, err = mac.Write(ciphertext)
 	if err != nil {
 		return err
 	}
@@ -110,6 +237,10 @@ func (s *Session) Unmarshal(key []byte, token []byte) error {
 		return errors.New("signature does not match content")
 	}
 
+	j, err := store.Decrypt(ciphertext)
+	if err != nil {
+		return err
+	}
 	err = json.Unmarshal(j, &s)
 	if err != nil {
 		return err
@@ -118,6 +249,23 @@ func (s *Session) Unmarshal(key []byte, token []byte) error {
 	if time.Since(s.Deadline) > 0 {
 		return errors.New("deadline for session has expired")
 	}
+	if s.Purpose != purpose {
+		return fmt.Errorf("session was created for %s, not %s", s.Purpose, purpose)
+	}
 
 	return nil
 }
+
+// invalidAssertion reports whether the error is from an assertion that doesn't validate, eg. a bad signature, challenge
+// or origin, rather than from a bad request or session
+func invalidAssertion(err error) bool {
+	var e *protocol.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Type {
+	case protocol.ErrChallengeMismatch.Type, protocol.ErrAuthData.Type, protocol.ErrVerification.Type, protocol.ErrAssertionSignature.Type:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package servw6n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/go-webauthn/webauthn/metadata/providers/memory"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+var ErrAuthenticatorNotAllowed = errors.New("authenticator is not allowed")
+
+// loadMetadata reads a FIDO MDS3 blob, the JWT downloaded from https://mds3.fidoalliance.org/, and verifies that it's
+// signed by a certificate chaining to root, base64 DER, or to the FIDO Alliance root if root is empty
+func loadMetadata(path string, root string) (metadata.Provider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	opts := []metadata.DecoderOption{metadata.WithIgnoreEntryParsingErrors()}
+	if root != "" {
+		opts = append(opts, metadata.WithRootCertificate(root))
+	}
+	decoder, err := metadata.NewDecoder(opts...)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decoder.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify metadata blob: %w", err)
+	}
+	mds, err := decoder.Parse(payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse metadata blob: %w", err)
+	}
+	if time.Now().After(mds.Parsed.NextUpdate) {
+		fmt.Printf("webauthn metadata blob is outdated, it should have been updated %s\n", mds.Parsed.NextUpdate.Format(time.DateOnly))
+	}
+
+	// The provider rejects authenticators that are missing in the blob, that have an undesired status, eg. revoked, or
+	// whose attestation doesn't chain to the attestation roots of their entry
+	return memory.New(
+		memory.WithMetadata(mds.ToMap()),
+		memory.WithValidateEntry(true),
+		memory.WithValidateEntryPermitZeroAAGUID(false),
+		memory.WithValidateTrustAnchor(true),
+		memory.WithValidateStatus(true),
+		memory.WithValidateAttestationTypes(true),
+	)
+}
+
+func toConveyance(mode string, requireMetadata bool) protocol.ConveyancePreference {
+	switch mode {
+	case "indirect":
+		return protocol.PreferIndirectAttestation
+	case "direct":
+		return protocol.PreferDirectAttestation
+	case "enterprise":
+		return protocol.PreferEnterpriseAttestation
+	}
+	// Metadata can't be verified without an attestation, and browsers remove it unless it's asked for
+	if requireMetadata {
+		return protocol.PreferDirectAttestation
+	}
+	return protocol.PreferNoAttestation
+}
+
+type attestationPolicy struct {
+	metadata bool
+	allow    []uuid.UUID
+	deny     []uuid.UUID
+}
+
+func newAttestationPolicy(requireMetadata bool, allow []string, deny []string) (policy attestationPolicy, err error) {
+	policy.metadata = requireMetadata
+	policy.allow, err = parseAAGUIDs(allow)
+	if err != nil {
+		return
+	}
+	policy.deny, err = parseAAGUIDs(deny)
+	return
+}
+
+func parseAAGUIDs(aaguids []string) ([]uuid.UUID, error) {
+	var parsed []uuid.UUID
+	for _, a := range aaguids {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		id, err := uuid.Parse(strings.TrimSpace(a))
+		if err != nil {
+			return nil, fmt.Errorf("invalid aaguid %q: %w", a, err)
+		}
+		parsed = append(parsed, id)
+	}
+	return parsed, nil
+}
+
+// verify checks the registered credential against the policy. The attestation itself, and the metadata of the
+// authenticator, is verified by webauthn when the credential is created. Note that the AAGUID of a credential without
+// attestation is only claimed by the authenticator, allow and deny lists should be combined with required metadata
+func (p attestationPolicy) verify(ctx context.Context, mds metadata.Provider, credential *webauthn.Credential) error {
+	aaguid, err := uuid.FromBytes(credential.Authenticator.AAGUID)
+	if err != nil {
+		return fmt.Errorf("invalid aaguid: %w", err)
+	}
+	if slices.Contains(p.deny, aaguid) {
+		return fmt.Errorf("%w: %s is denied", ErrAuthenticatorNotAllowed, aaguid)
+	}
+	if len(p.allow) > 0 && !slices.Contains(p.allow, aaguid) {
+		return fmt.Errorf("%w: %s is not in the allow list", ErrAuthenticatorNotAllowed, aaguid)
+	}
+
+	if !p.metadata {
+		return nil
+	}
+	if credential.AttestationType == "" || credential.AttestationType == string(protocol.AttestationFormatNone) {
+		return fmt.Errorf("%w: no attestation was provided", ErrAuthenticatorNotAllowed)
+	}
+	entry, err := mds.GetEntry(ctx, aaguid)
+	if err != nil {
+		return err
+	}
+	if entry == nil || !certified(entry.StatusReports) {
+		return fmt.Errorf("%w: %s is not FIDO certified", ErrAuthenticatorNotAllowed, aaguid)
+	}
+	return nil
+}
+
+// certified reports whether the authenticator has a FIDO certification, at any level. Statuses that revoke it, eg.
+// REVOKED or USER_KEY_PHYSICAL_COMPROMISE, are rejected by the metadata provider
+func certified(reports []metadata.StatusReport) bool {
+	for _, r := range reports {
+		if strings.HasPrefix(string(r.Status), string(metadata.FidoCertified)) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,25 @@
 package servw6n
 
 type Config struct {
-	RPID             string `json:"RPID,omitempty"`
-	RPDisplayName    string `json:"RPDisplayName,omitempty"`
-	RPOrigin         string `json:"RPOrigin,omitempty"`
-	UserVerification string `json:"UserVerification,omitempty"`
+	RPID          string `json:"RPID,omitempty"`
+	RPDisplayName string `json:"RPDisplayName,omitempty"`
+	RPOrigin      string `json:"RPOrigin,omitempty"`
+	// RPOrigins are allowed in addition to RPOrigin, eg. subdomains or "android:apk-key-hash:<hash>" of Android apps
+	RPOrigins []string `json:"RPOrigins,omitempty"`
+	// RPTopOrigins are the sites that may embed the RP in a cross-origin iframe, in addition to the RP origins
+	RPTopOrigins     []string `json:"RPTopOrigins,omitempty"`
+	UserVerification string   `json:"UserVerification,omitempty"`
+	// ResidentKey can be required/preferred/discouraged, required makes the credential discoverable so that it can be
+	// used for usernameless login
+	ResidentKey string `json:"ResidentKey,omitempty"`
+	// Attestation is the attestation conveyance preference, none/indirect/direct/enterprise
+	Attestation string `json:"Attestation,omitempty"`
+	// RequireMetadata only allows FIDO certified authenticators, with an attestation that is verified against the
+	// metadata blob the server is configured with
+	RequireMetadata bool `json:"RequireMetadata,omitempty"`
+	// AAGUIDAllow and AAGUIDDeny are lists of authenticator models, eg. "cb69481e-8ff7-4039-93ec-0a2729a154a8"
+	AAGUIDAllow []string `json:"AAGUIDAllow,omitempty"`
+	AAGUIDDeny  []string `json:"AAGUIDDeny,omitempty"`
 }
 
 type EnrollUser struct {
@@ -13,9 +28,10 @@ type EnrollUser struct {
 }
 
 type EnrollInitReq struct {
-	User     *EnrollUser `json:"user,omitempty"`
-	UserBlob []byte      `json:"userBlob,omitempty"`
-	Cfg      *Config     `json:"cfg,omitempty"`
+	User       *EnrollUser `json:"user,omitempty"`
+	UserBlob   []byte      `json:"userBlob,omitempty"`
+	Cfg        *Config     `json:"cfg,omitempty"`
+	Extensions *Extensions `json:"extensions,omitempty"`
 }
 
 func (m *EnrollInitReq) GetUser() *EnrollUser {
@@ -40,8 +56,11 @@ func (m *EnrollInitReq) GetCfg() *Config {
 }
 
 type AuthInitReq struct {
-	UserBlob []byte  `json:"userBlob,omitempty"`
-	Cfg      *Config `json:"cfg,omitempty"`
+	UserBlob   []byte      `json:"userBlob,omitempty"`
+	Cfg        *Config     `json:"cfg,omitempty"`
+	Extensions *Extensions `json:"extensions,omitempty"`
+	// EndUserIp is used to rate limit discoverable logins, that are started without a userBlob
+	EndUserIp string `json:"endUserIp,omitempty"`
 }
 
 func (m *AuthInitReq) GetUserBlob() []byte {
@@ -51,6 +70,13 @@ func (m *AuthInitReq) GetUserBlob() []byte {
 	return nil
 }
 
+func (m *AuthInitReq) GetEndUserIp() string {
+	if m != nil {
+		return m.EndUserIp
+	}
+	return ""
+}
+
 func (m *AuthInitReq) GetCfg() *Config {
 	if m != nil {
 		return m.Cfg
@@ -81,6 +107,10 @@ type FinalReq struct {
 	Session   []byte  `json:"session,omitempty"`
 	Signature []byte  `json:"signature,omitempty"`
 	Cfg       *Config `json:"cfg,omitempty"`
+	// UserBlob is only used to complete discoverable logins, with the blob of the user in FinalRes.UserHandle
+	UserBlob []byte `json:"userBlob,omitempty"`
+	// EndUserIp is used to rate limit the first call of a discoverable login, that is made without a userBlob
+	EndUserIp string `json:"endUserIp,omitempty"`
 }
 
 func (m *FinalReq) GetSession() []byte {
@@ -97,6 +127,20 @@ func (m *FinalReq) GetSignature() []byte {
 	return nil
 }
 
+func (m *FinalReq) GetUserBlob() []byte {
+	if m != nil {
+		return m.UserBlob
+	}
+	return nil
+}
+
+func (m *FinalReq) GetEndUserIp() string {
+	if m != nil {
+		return m.EndUserIp
+	}
+	return ""
+}
+
 func (m *FinalReq) GetCfg() *Config {
 	if m != nil {
 		return m.Cfg
@@ -105,8 +149,18 @@ func (m *FinalReq) GetCfg() *Config {
 }
 
 type FinalRes struct {
-	Valid    bool   `json:"valid,omitempty"`
+	Valid bool `json:"valid,omitempty"`
+	// UserBlob is returned on enroll, and on a valid auth with an updated sign count, backup state and last used time.
+	// It shall replace the persisted blob
 	UserBlob []byte `json:"userBlob,omitempty"`
+	// CloneWarning is set on auth when the sign count of the authenticator didn't increase, which indicates that the
+	// credential may have been cloned. The warning persists in the userBlob for the credential
+	CloneWarning bool `json:"cloneWarning,omitempty"`
+	// UserHandle is the id of the user that signed. For a discoverable login without a userBlob it's the only thing
+	// returned, look up the userBlob of the user and call AuthFinal again with it
+	UserHandle string `json:"userHandle,omitempty"`
+	// Extensions are the client extension results passed on by the frontend in the signature
+	Extensions *ExtensionOutputs `json:"extensions,omitempty"`
 }
 
 func (m *FinalRes) GetUserBlob() []byte {
@@ -115,3 +169,76 @@ func (m *FinalRes) GetUserBlob() []byte {
 	}
 	return nil
 }
+
+type Blob struct {
+	UserBlob []byte `json:"userBlob,omitempty"`
+}
+
+type CredentialSummary struct {
+	ID         []byte   `json:"id,omitempty"`
+	RPID       string   `json:"rpId,omitempty"`
+	AAGUID     string   `json:"aaguid,omitempty"`
+	Nickname   string   `json:"nickname,omitempty"`
+	Created    int64    `json:"created,omitempty"`
+	LastUsed   int64    `json:"lastUsed,omitempty"`
+	Transports []string `json:"transports,omitempty"`
+	PRF        bool     `json:"prf,omitempty"`
+	LargeBlob  bool     `json:"largeBlob,omitempty"`
+}
+
+type CredentialsRes struct {
+	Credentials []CredentialSummary `json:"credentials"`
+}
+
+type RenameReq struct {
+	UserBlob []byte `json:"userBlob,omitempty"`
+	ID       []byte `json:"id,omitempty"`
+	Nickname string `json:"nickname,omitempty"`
+}
+
+type RemoveReq struct {
+	UserBlob []byte `json:"userBlob,omitempty"`
+	ID       []byte `json:"id,omitempty"`
+}
+
+// Extensions are the inputs of the WebAuthn extensions passed to the authenticator
+type Extensions struct {
+	PRF       *PRFInputs       `json:"prf,omitempty"`
+	LargeBlob *LargeBlobInputs `json:"largeBlob,omitempty"`
+}
+
+// PRFInputs are the salts to evaluate the prf of the credential with. The browser hashes them with a context string,
+// so the outputs can't be used to sign other WebAuthn challenges
+type PRFInputs struct {
+	Eval *PRFValues `json:"eval,omitempty"`
+	// EvalByCredential holds salts per credential, by base64url credential id, it can only be used on auth
+	EvalByCredential map[string]PRFValues `json:"evalByCredential,omitempty"`
+}
+
+type PRFValues struct {
+	First  []byte `json:"first,omitempty"`
+	Second []byte `json:"second,omitempty"`
+}
+
+// LargeBlobInputs asks for largeBlob support, required/preferred, on enroll. On auth the blob is either read or written
+type LargeBlobInputs struct {
+	Support string `json:"support,omitempty"`
+	Read    bool   `json:"read,omitempty"`
+	Write   []byte `json:"write,omitempty"`
+}
+
+type ExtensionOutputs struct {
+	PRF       *PRFOutputs       `json:"prf,omitempty"`
+	LargeBlob *LargeBlobOutputs `json:"largeBlob,omitempty"`
+}
+
+type PRFOutputs struct {
+	Enabled bool       `json:"enabled,omitempty"`
+	Results *PRFValues `json:"results,omitempty"`
+}
+
+type LargeBlobOutputs struct {
+	Supported bool   `json:"supported,omitempty"`
+	Blob      []byte `json:"blob,omitempty"`
+	Written   bool   `json:"written,omitempty"`
+}
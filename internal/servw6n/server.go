@@ -6,31 +6,80 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-webauthn/webauthn/metadata"
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/modfin/twofer/internal/config"
+	"github.com/modfin/twofer/internal/crypt"
 	"github.com/modfin/twofer/internal/ratelimit"
+	"github.com/modfin/twofer/internal/replay"
 	"time"
 )
 
-func New(config config.WebAuthn) (*Server, error) {
+// New creates a WebAuthn server. If consumed is set, sessions are single use, their challenges are consumed when a
+// final call succeeds
+func New(config config.WebAuthn, consumed replay.Cache) (*Server, error) {
+	if config.HMACKey == "" {
+		return nil, errors.New("a hmac key must be provided to sign sessions")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+	// The userBlob holds the public keys of the credentials, it must be sealed so that a caller can't inject its own
+	if len(config.EncryptionKey) == 0 {
+		return nil, errors.New("an encryption key must be provided")
+	}
+	store, err := crypt.New(config.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	var mds metadata.Provider
+	if config.MDSBlob != "" {
+		mds, err = loadMetadata(config.MDSBlob, config.MDSRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.RequireMetadata && mds == nil {
+		return nil, errors.New("a metadata blob must be provided to require metadata")
+	}
+	policy, err := newAttestationPolicy(config.RequireMetadata, config.AAGUIDAllow, config.AAGUIDDeny)
+	if err != nil {
+		return nil, err
+	}
+	rpOrigins, err := origins(config.RPID, config.RPOrigin, config.RPOrigins)
+	if err != nil {
+		return nil, err
+	}
+	rpTopOrigins, err := topOrigins(config.RPTopOrigins)
+	if err != nil {
+		return nil, err
+	}
 	s := &Server{
-		ratelimiter: ratelimit.New(config.RateLimit),
-		hmacKey:     []byte(config.HMACKey),
-		timeout:     config.Timeout,
+		store:         store,
+		consumed:      consumed,
+		mds:           mds,
+		defaultPolicy: policy,
+		ratelimiter:   ratelimit.New(config.RateLimit),
+		hmacKey:       []byte(config.HMACKey),
+		timeout:       config.Timeout,
 		defaultConfig: &webauthn.Config{
 			RPDisplayName: config.RPDisplayName,
 			RPID:          config.RPID,
-			RPOrigin:      config.RPOrigin,
-			RPIcon:        "",
-			AuthenticatorSelection: protocol.AuthenticatorSelection{
-				UserVerification: toUserVerification(config.UserVerification),
-			},
-			Timeout: int(config.Timeout.Milliseconds()),
-			Debug:   false,
+			RPOrigins:     rpOrigins,
+			RPTopOrigins:  rpTopOrigins,
+			// Cross-origin requests must be embedded by a top origin, or by one of the RP origins
+			RPTopOriginVerificationMode: protocol.TopOriginAutoVerificationMode,
+			AuthenticatorSelection:      toAuthenticatorSelection(config.UserVerification, config.ResidentKey),
+			AttestationPreference:       toConveyance(config.Attestation, config.RequireMetadata),
+			Timeouts:                    timeouts(config.Timeout),
+			Debug:                       false,
 		},
 	}
-	_, err := webauthn.New(s.defaultConfig)
+	if config.RequireMetadata {
+		s.defaultConfig.MDS = mds
+	}
+	_, err = webauthn.New(s.defaultConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -39,12 +88,34 @@ func New(config config.WebAuthn) (*Server, error) {
 }
 
 type Server struct {
+	store         crypt.Store
+	consumed      replay.Cache
+	mds           metadata.Provider
+	defaultPolicy attestationPolicy
 	ratelimiter   *ratelimit.Ratelimiter
 	defaultConfig *webauthn.Config
 	hmacKey       []byte
 	timeout       time.Duration
 }
 
+func (s *Server) open(userBlob []byte) (User, error) {
+	var u User
+	b, err := s.store.Decrypt(userBlob)
+	if err != nil {
+		return u, fmt.Errorf("could not decrypt userBlob: %w", err)
+	}
+	err = json.Unmarshal(b, &u)
+	return u, err
+}
+
+func (s *Server) seal(u User) ([]byte, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Encrypt(b)
+}
+
 func (s *Server) create(c interface{ GetCfg() *Config }) (*webauthn.WebAuthn, error) {
 	if c == nil {
 		return webauthn.New(s.defaultConfig)
@@ -54,14 +125,57 @@ func (s *Server) create(c interface{ GetCfg() *Config }) (*webauthn.WebAuthn, er
 		return webauthn.New(s.defaultConfig)
 	}
 
-	return webauthn.New(&webauthn.Config{
-		RPDisplayName: cfg.RPDisplayName,
-		RPID:          cfg.RPID,
-		RPOrigin:      cfg.RPOrigin,
-		AuthenticatorSelection: protocol.AuthenticatorSelection{
-			UserVerification: toUserVerification(cfg.UserVerification),
-		},
-	})
+	rpOrigins, err := origins(cfg.RPID, cfg.RPOrigin, cfg.RPOrigins)
+	if err != nil {
+		return nil, err
+	}
+	rpTopOrigins, err := topOrigins(cfg.RPTopOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &webauthn.Config{
+		RPDisplayName:               cfg.RPDisplayName,
+		RPID:                        cfg.RPID,
+		RPOrigins:                   rpOrigins,
+		RPTopOrigins:                rpTopOrigins,
+		RPTopOriginVerificationMode: protocol.TopOriginAutoVerificationMode,
+		AuthenticatorSelection:      toAuthenticatorSelection(cfg.UserVerification, cfg.ResidentKey),
+		AttestationPreference:       toConveyance(cfg.Attestation, cfg.RequireMetadata),
+		Timeouts:                    timeouts(s.timeout),
+	}
+	if cfg.RequireMetadata {
+		if s.mds == nil {
+			return nil, errors.New("metadata can't be required, no metadata blob is configured")
+		}
+		config.MDS = s.mds
+	}
+	return webauthn.New(config)
+}
+
+// consume marks the challenge of the session as used, it returns false if it already has been
+func (s *Server) consume(session Session) (bool, error) {
+	if s.consumed == nil {
+		return true, nil
+	}
+	return s.consumed.Consume(session.Data.Challenge, session.Deadline)
+}
+
+// policy returns the attestation policy of the request config, or the default one
+func (s *Server) policy(c interface{ GetCfg() *Config }) (attestationPolicy, error) {
+	cfg := c.GetCfg()
+	if cfg == nil {
+		return s.defaultPolicy, nil
+	}
+	return newAttestationPolicy(cfg.RequireMetadata, cfg.AAGUIDAllow, cfg.AAGUIDDeny)
+}
+
+// hitEndUser rate limits calls that aren't made for a known user by the IP of the end user, if it's passed
+func (s *Server) hitEndUser(ip string) error {
+	if ip == "" {
+		return nil
+	}
+	return s.ratelimiter.Hit("ip:" + ip)
 }
 
 func (s *Server) EnrollInit(_ context.Context, req *EnrollInitReq) (res *InitRes, err error) {
@@ -73,9 +187,9 @@ func (s *Server) EnrollInit(_ context.Context, req *EnrollInitReq) (res *InitRes
 
 	var u User
 	if len(req.UserBlob) > 0 {
-		err = json.Unmarshal(req.UserBlob, &u)
+		u, err = s.open(req.UserBlob)
 		if err != nil {
-			return
+			return nil, err
 		}
 	}
 
@@ -104,7 +218,16 @@ func (s *Server) EnrollInit(_ context.Context, req *EnrollInitReq) (res *InitRes
 		return nil, err
 	}
 
-	credentialCreation, sessionData, err := service.BeginRegistration(u)
+	extensions, err := req.Extensions.registration()
+	if err != nil {
+		return nil, err
+	}
+	var opts []webauthn.RegistrationOption
+	if len(extensions) > 0 {
+		opts = append(opts, webauthn.WithExtensions(extensions))
+	}
+
+	credentialCreation, sessionData, err := service.BeginRegistration(u, opts...)
 	if err != nil {
 		return
 	}
@@ -116,9 +239,13 @@ func (s *Server) EnrollInit(_ context.Context, req *EnrollInitReq) (res *InitRes
 
 	session, err := Session{
 		Deadline: time.Now().Add(s.timeout),
+		Purpose:  PurposeEnroll,
 		Data:     sessionData,
 		User:     u,
-	}.Marshal(s.hmacKey)
+	}.Marshal(s.hmacKey, s.store)
+	if err != nil {
+		return
+	}
 
 	response := &InitRes{
 		Session: session,
@@ -127,7 +254,7 @@ func (s *Server) EnrollInit(_ context.Context, req *EnrollInitReq) (res *InitRes
 	return response, nil
 }
 
-func (s *Server) EnrollFinal(_ context.Context, req *FinalReq) (res *FinalRes, err error) {
+func (s *Server) EnrollFinal(ctx context.Context, req *FinalReq) (res *FinalRes, err error) {
 
 	service, err := s.create(req)
 	if err != nil {
@@ -139,7 +266,7 @@ func (s *Server) EnrollFinal(_ context.Context, req *FinalReq) (res *FinalRes, e
 	}
 
 	var session Session
-	err = session.Unmarshal(s.hmacKey, req.Session)
+	err = session.Unmarshal(s.hmacKey, s.store, req.Session, PurposeEnroll)
 	if err != nil {
 		return nil, err
 	}
@@ -159,12 +286,47 @@ func (s *Server) EnrollFinal(_ context.Context, req *FinalReq) (res *FinalRes, e
 		return nil, err
 	}
 
-	session.User.Credentials = append(session.User.Credentials, Credential{Credential: *credential, RPID: service.Config.RPID})
+	extensions, err := extensionOutputs(credentialCreation.ClientExtensionResults)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := s.policy(req)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.verify(ctx, s.mds, credential)
+	if err != nil {
+		return nil, err
+	}
 
-	res = &FinalRes{}
-	res.UserBlob, err = json.Marshal(session.User)
+	ok, err := s.consume(session)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSessionUsed
+	}
+
+	enrolled := Credential{
+		Credential: *credential,
+		RPID:       service.Config.RPID,
+		Created:    time.Now().Unix(),
+	}
+	if extensions != nil {
+		enrolled.PRF = extensions.PRF != nil && extensions.PRF.Enabled
+		enrolled.LargeBlob = extensions.LargeBlob != nil && extensions.LargeBlob.Supported
+	}
+	session.User.Credentials = append(session.User.Credentials, enrolled)
+
+	res = &FinalRes{Extensions: extensions}
+	res.UserBlob, err = s.seal(session.User)
 	return res, err
 }
+
+// AuthInit starts a login for the user in the userBlob. Without a userBlob a discoverable, usernameless, login is
+// started, where the authenticator picks one of its resident credentials for the RP. There is no user to rate limit a
+// discoverable login by, it's rate limited by the EndUserIp if passed, otherwise the caller must rate limit it
 func (s *Server) AuthInit(_ context.Context, req *AuthInitReq) (res *InitRes, err error) {
 
 	service, err := s.create(req)
@@ -172,37 +334,45 @@ func (s *Server) AuthInit(_ context.Context, req *AuthInitReq) (res *InitRes, er
 		return nil, err
 	}
 
-	var u User
-	err = json.Unmarshal(req.UserBlob, &u)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	err = s.ratelimiter.Hit(u.Id)
+	extensions, err := req.Extensions.assertion()
 	if err != nil {
 		return nil, err
 	}
+	var opts []webauthn.LoginOption
+	if len(extensions) > 0 {
+		opts = append(opts, webauthn.WithAssertionExtensions(extensions))
+	}
 
-	// Filtering out credentials valid for this RP
-	var credentials []Credential
-	for _, c := range u.Credentials {
-		if c.RPID == service.Config.RPID {
-			credentials = append(credentials, c)
+	var credentialAssertion *protocol.CredentialAssertion
+	session := Session{Deadline: time.Now().Add(s.timeout), Purpose: PurposeAuth}
+	if len(req.UserBlob) == 0 {
+		err = s.hitEndUser(req.EndUserIp)
+		if err != nil {
+			return nil, err
+		}
+		session.Discoverable = true
+		credentialAssertion, session.Data, err = service.BeginDiscoverableLogin(opts...)
+		if err != nil {
+			return
+		}
+	} else {
+		session.User, err = s.open(req.UserBlob)
+		if err != nil {
+			return nil, err
 		}
-	}
-	u.Credentials = credentials
 
-	credentialAssertion, sessionData, err := service.BeginLogin(u)
-	if err != nil {
-		return
+		err = s.ratelimiter.Hit(session.User.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		credentialAssertion, session.Data, err = service.BeginLogin(session.User.forRP(service.Config.RPID), opts...)
+		if err != nil {
+			return
+		}
 	}
 
-	session, err := Session{
-		Deadline: time.Now().Add(s.timeout),
-		User:     u,
-		Data:     sessionData,
-	}.Marshal(s.hmacKey)
+	token, err := session.Marshal(s.hmacKey, s.store)
 	if err != nil {
 		return
 	}
@@ -213,10 +383,15 @@ func (s *Server) AuthInit(_ context.Context, req *AuthInitReq) (res *InitRes, er
 	}
 
 	return &InitRes{
-		Session: session,
+		Session: token,
 		Json:    response,
 	}, nil
 }
+
+// AuthFinal validates the signature from the authenticator. For a discoverable login the userBlob is not known when
+// the session is created, if it's not passed the user handle is returned without validating, and AuthFinal shall be
+// called again with the same session and signature together with the userBlob of that user. The first call is rate
+// limited by the EndUserIp, as AuthInit, and the second by the user
 func (s *Server) AuthFinal(_ context.Context, req *FinalReq) (res *FinalRes, err error) {
 
 	service, err := s.create(req)
@@ -225,31 +400,112 @@ func (s *Server) AuthFinal(_ context.Context, req *FinalReq) (res *FinalRes, err
 	}
 
 	body, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Signature))
-
-	var session Session
-	err = session.Unmarshal(s.hmacKey, req.Session)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("failed to parse authenticator response")
 	}
 
-	err = s.ratelimiter.Hit(session.User.Id)
+	var session Session
+	err = session.Unmarshal(s.hmacKey, s.store, req.Session, PurposeAuth)
 	if err != nil {
 		return nil, err
 	}
 
 	if session.Data == nil {
 		return nil, errors.New("session data must be provided")
+	}
 
+	if session.Discoverable {
+		userHandle := string(body.Response.UserHandle)
+		if userHandle == "" {
+			return nil, errors.New("a user handle must be provided by the authenticator")
+		}
+		if len(req.UserBlob) == 0 {
+			err = s.hitEndUser(req.EndUserIp)
+			if err != nil {
+				return nil, err
+			}
+			return &FinalRes{UserHandle: userHandle}, nil
+		}
+		session.User, err = s.open(req.UserBlob)
+		if err != nil {
+			return nil, err
+		}
+		if session.User.Id != userHandle {
+			return nil, errors.New("userBlob does not belong to the user handle")
+		}
 	}
-	_, err = service.ValidateLogin(session.User, *session.Data, body)
+
+	err = s.ratelimiter.Hit(session.User.Id)
 	if err != nil {
+		return nil, err
+	}
+
+	var credential *webauthn.Credential
+	user := session.User.forRP(service.Config.RPID)
+	if session.Discoverable {
+		credential, err = service.ValidateDiscoverableLogin(func(_, _ []byte) (webauthn.User, error) {
+			return user, nil
+		}, *session.Data, body)
+	} else {
+		credential, err = service.ValidateLogin(user, *session.Data, body)
+	}
+	if invalidAssertion(err) {
+		// A signature that doesn't validate is an invalid login, not a failed request
 		return &FinalRes{
 			Valid: false,
-		}, err
+		}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &FinalRes{
-		Valid: true,
-	}, nil
+	extensions, err := extensionOutputs(body.ClientExtensionResults)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.consume(session)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSessionUsed
+	}
+
+	// The session holds all of the users credentials, so that the returned userBlob keeps the ones of other RPs
+	for i, c := range session.User.Credentials {
+		if c.RPID == service.Config.RPID && bytes.Equal(c.ID, credential.ID) {
+			session.User.Credentials[i].Credential = *credential
+			session.User.Credentials[i].LastUsed = time.Now().Unix()
+			// Support may first be seen on login, eg. for credentials enrolled before the extensions were asked for
+			if extensions != nil && extensions.PRF != nil && extensions.PRF.Enabled {
+				session.User.Credentials[i].PRF = true
+			}
+			if extensions != nil && extensions.LargeBlob != nil && (extensions.LargeBlob.Written || len(extensions.LargeBlob.Blob) > 0) {
+				session.User.Credentials[i].LargeBlob = true
+			}
+		}
+	}
+
+	res = &FinalRes{
+		Valid:        true,
+		UserHandle:   session.User.Id,
+		CloneWarning: credential.Authenticator.CloneWarning,
+		Extensions:   extensions,
+	}
+	res.UserBlob, err = s.seal(session.User)
+	return res, err
+}
 
+// Upgrade re-encrypts the blob using the latest encryption key
+func (s *Server) Upgrade(_ context.Context, req *Blob) (*Blob, error) {
+	u, err := s.open(req.UserBlob)
+	if err != nil {
+		return nil, err
+	}
+	userBlob, err := s.seal(u)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{UserBlob: userBlob}, nil
 }
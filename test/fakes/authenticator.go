@@ -0,0 +1,268 @@
+package fakes
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Authenticator is a software WebAuthn authenticator, it creates ES256 credentials with "none", or "packed", attestation
+// and signs assertions with them, the way a browser and a security key would together
+type Authenticator struct {
+	Origin string
+	// TopOrigin, when set, is the site embedding the origin in a cross-origin iframe
+	TopOrigin string
+	AAGUID    [16]byte
+	// Attestation, when set, makes the authenticator a model with packed attestation and the AAGUID of the attestation
+	Attestation *Attestation
+	// PRF and LargeBlob enable support for the extensions, as the browser reports them in the client extension results
+	PRF       bool
+	LargeBlob bool
+
+	credentials []*SoftCredential
+}
+
+type SoftCredential struct {
+	ID         []byte
+	RPID       string
+	UserHandle []byte
+	SignCount  uint32
+	key        *ecdsa.PrivateKey
+	prfSecret  []byte
+	largeBlob  []byte
+}
+
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Credentials returns the credentials created by the authenticator
+func (a *Authenticator) Credentials() []*SoftCredential {
+	return a.credentials
+}
+
+// Clone returns an authenticator with copies of the credentials, including their keys and sign counts, as an attacker
+// that has extracted them would have
+func (a *Authenticator) Clone() *Authenticator {
+	clone := &Authenticator{Origin: a.Origin, TopOrigin: a.TopOrigin, AAGUID: a.AAGUID, Attestation: a.Attestation, PRF: a.PRF, LargeBlob: a.LargeBlob}
+	for _, c := range a.credentials {
+		cc := *c
+		clone.credentials = append(clone.credentials, &cc)
+	}
+	return clone
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+	TopOrigin   string `json:"topOrigin,omitempty"`
+}
+
+type attestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+	ClientExtensionResults *extensionOutputs `json:"clientExtensionResults,omitempty"`
+}
+
+type assertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+	ClientExtensionResults *extensionOutputs `json:"clientExtensionResults,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Create takes the json from EnrollInit, a protocol.CredentialCreation, and returns the signature for EnrollFinal
+func (a *Authenticator) Create(creationJSON []byte) ([]byte, error) {
+	var creation protocol.CredentialCreation
+	err := json.Unmarshal(creationJSON, &creation)
+	if err != nil {
+		return nil, err
+	}
+	opts := creation.Response
+	inputs, err := parseExtensions(opts.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cred := &SoftCredential{ID: make([]byte, 32), RPID: opts.RelyingParty.ID, key: key, prfSecret: make([]byte, 32)}
+	_, err = rand.Read(cred.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rand.Read(cred.prfSecret)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := opts.User.ID.(string); ok {
+		cred.UserHandle, err = b64.DecodeString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user id: %w", err)
+		}
+	}
+
+	cd, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	aaguid := a.AAGUID
+	if a.Attestation != nil {
+		aaguid = a.Attestation.AAGUID
+	}
+	var authData bytes.Buffer
+	authData.Write(a.authData(cred, flagUserPresent|flagUserVerified|flagAttested))
+	authData.Write(aaguid[:])
+	_ = binary.Write(&authData, binary.BigEndian, uint16(len(cred.ID)))
+	authData.Write(cred.ID)
+	authData.Write(pub)
+
+	format, statement := "none", map[string]any{}
+	if a.Attestation != nil {
+		format = "packed"
+		statement, err = a.Attestation.statement(authData.Bytes(), cd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res attestationResponse
+	res.ID = b64.EncodeToString(cred.ID)
+	res.RawID = res.ID
+	res.Type = "public-key"
+	res.Response.ClientDataJSON = b64.EncodeToString(cd)
+	res.Response.AttestationObject = b64.EncodeToString(attestation)
+	res.ClientExtensionResults = a.extensions(cred, inputs, true)
+
+	a.credentials = append(a.credentials, cred)
+	return json.Marshal(res)
+}
+
+// Get takes the json from AuthInit, a protocol.PublicKeyCredentialRequestOptions, and returns the signature for
+// AuthFinal, using the first credential that is allowed
+func (a *Authenticator) Get(requestJSON []byte) ([]byte, error) {
+	var opts protocol.PublicKeyCredentialRequestOptions
+	err := json.Unmarshal(requestJSON, &opts)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := parseExtensions(opts.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := a.find(opts)
+	if cred == nil {
+		return nil, errors.New("no credential found for the relying party")
+	}
+	cred.SignCount++
+
+	cd, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData := a.authData(cred, flagUserPresent|flagUserVerified)
+	// The signature is over the authenticator data followed by the hash of the client data
+	cdHash := sha256.Sum256(cd)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var res assertionResponse
+	res.ID = b64.EncodeToString(cred.ID)
+	res.RawID = res.ID
+	res.Type = "public-key"
+	res.Response.ClientDataJSON = b64.EncodeToString(cd)
+	res.Response.AuthenticatorData = b64.EncodeToString(authData)
+	res.Response.Signature = b64.EncodeToString(signature)
+	res.Response.UserHandle = b64.EncodeToString(cred.UserHandle)
+	res.ClientExtensionResults = a.extensions(cred, inputs, false)
+	return json.Marshal(res)
+}
+
+func (a *Authenticator) find(opts protocol.PublicKeyCredentialRequestOptions) *SoftCredential {
+	for _, c := range a.credentials {
+		if c.RPID != opts.RelyingPartyID {
+			continue
+		}
+		if len(opts.AllowedCredentials) == 0 {
+			return c
+		}
+		for _, allowed := range opts.AllowedCredentials {
+			if bytes.Equal(allowed.CredentialID, c.ID) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(clientData{
+		Type:        typ,
+		Challenge:   challenge.String(),
+		Origin:      a.Origin,
+		CrossOrigin: a.TopOrigin != "",
+		TopOrigin:   a.TopOrigin,
+	})
+}
+
+func (a *Authenticator) authData(cred *SoftCredential, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.RPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, cred.SignCount)
+}
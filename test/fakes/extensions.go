@@ -0,0 +1,100 @@
+package fakes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+type prfValues struct {
+	First  protocol.URLEncodedBase64 `json:"first"`
+	Second protocol.URLEncodedBase64 `json:"second,omitempty"`
+}
+
+type extensionInputs struct {
+	PRF *struct {
+		Eval             *prfValues           `json:"eval,omitempty"`
+		EvalByCredential map[string]prfValues `json:"evalByCredential,omitempty"`
+	} `json:"prf,omitempty"`
+	LargeBlob *struct {
+		Support string                    `json:"support,omitempty"`
+		Read    bool                      `json:"read,omitempty"`
+		Write   protocol.URLEncodedBase64 `json:"write,omitempty"`
+	} `json:"largeBlob,omitempty"`
+}
+
+type extensionOutputs struct {
+	PRF *struct {
+		Enabled bool       `json:"enabled,omitempty"`
+		Results *prfValues `json:"results,omitempty"`
+	} `json:"prf,omitempty"`
+	LargeBlob *struct {
+		Supported bool                      `json:"supported,omitempty"`
+		Blob      protocol.URLEncodedBase64 `json:"blob,omitempty"`
+		Written   bool                      `json:"written,omitempty"`
+	} `json:"largeBlob,omitempty"`
+}
+
+func parseExtensions(ext protocol.AuthenticationExtensions) (extensionInputs, error) {
+	var inputs extensionInputs
+	if len(ext) == 0 {
+		return inputs, nil
+	}
+	b, err := json.Marshal(ext)
+	if err != nil {
+		return inputs, err
+	}
+	err = json.Unmarshal(b, &inputs)
+	return inputs, err
+}
+
+// extensions returns the client extension results of the prf and largeBlob extensions for the credential, the way a
+// browser does from the outputs of the hmac-secret and largeBlob authenticator extensions
+func (a *Authenticator) extensions(cred *SoftCredential, inputs extensionInputs, create bool) *extensionOutputs {
+	var out extensionOutputs
+	if inputs.PRF != nil && a.PRF {
+		out.PRF = &struct {
+			Enabled bool       `json:"enabled,omitempty"`
+			Results *prfValues `json:"results,omitempty"`
+		}{Enabled: create}
+		eval := inputs.PRF.Eval
+		if v, ok := inputs.PRF.EvalByCredential[b64.EncodeToString(cred.ID)]; ok {
+			eval = &v
+		}
+		if eval != nil {
+			out.PRF.Results = &prfValues{First: cred.prf(eval.First)}
+			if len(eval.Second) > 0 {
+				out.PRF.Results.Second = cred.prf(eval.Second)
+			}
+		}
+	}
+	if inputs.LargeBlob != nil && a.LargeBlob {
+		out.LargeBlob = &struct {
+			Supported bool                      `json:"supported,omitempty"`
+			Blob      protocol.URLEncodedBase64 `json:"blob,omitempty"`
+			Written   bool                      `json:"written,omitempty"`
+		}{Supported: create}
+		if !create && inputs.LargeBlob.Read {
+			out.LargeBlob.Blob = cred.largeBlob
+		}
+		if !create && len(inputs.LargeBlob.Write) > 0 {
+			cred.largeBlob = inputs.LargeBlob.Write
+			out.LargeBlob.Written = true
+		}
+	}
+	if out.PRF == nil && out.LargeBlob == nil {
+		return nil
+	}
+	return &out
+}
+
+// prf is the hmac-secret of the credential, with the salt hashed by the browser so that the outputs are separate from
+// those of other uses of hmac-secret
+func (c *SoftCredential) prf(input []byte) []byte {
+	salt := sha256.Sum256(append([]byte("WebAuthn PRF\x00"), input...))
+	mac := hmac.New(sha256.New, c.prfSecret)
+	mac.Write(salt[:])
+	return mac.Sum(nil)
+}
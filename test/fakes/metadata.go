@@ -0,0 +1,210 @@
+package fakes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var oidFidoAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// Attestation is the attestation key of an authenticator model, with a certificate issued by the vendor root. An
+// Authenticator with an Attestation returns "packed" attestations instead of "none"
+type Attestation struct {
+	AAGUID [16]byte
+	Root   []byte
+	Cert   []byte
+	key    *ecdsa.PrivateKey
+}
+
+func NewAttestation(aaguid uuid.UUID) (*Attestation, error) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	root, err := createCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Fake Vendor Attestation Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// The AAGUID extension is an OCTET STRING wrapped in the OCTET STRING of the extension value
+	ext, err := asn1.Marshal(aaguid[:])
+	if err != nil {
+		return nil, err
+	}
+	cert, err := createCertificate(&x509.Certificate{
+		Subject: pkix.Name{
+			Country:            []string{"SE"},
+			Organization:       []string{"Fake Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Fake Authenticator",
+		},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidFidoAAGUID, Value: ext}},
+	}, root, &key.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Attestation{AAGUID: aaguid, Root: root.Raw, Cert: cert.Raw, key: key}, nil
+}
+
+// statement returns the packed attestation statement, signing the authenticator data and the client data hash
+func (a *Attestation) statement(authData []byte, clientData []byte) (map[string]any, error) {
+	cdHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"alg": -7,
+		"sig": sig,
+		"x5c": [][]byte{a.Cert},
+	}, nil
+}
+
+// MetadataService creates FIDO MDS3 blobs, signed with a certificate chain from its own root the way the FIDO
+// Alliance signs theirs
+type MetadataService struct {
+	Root  []byte
+	chain [][]byte
+	key   *ecdsa.PrivateKey
+}
+
+type MetadataEntry struct {
+	Attestation *Attestation
+	Status      string
+}
+
+func NewMetadataService() (*MetadataService, error) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	root, err := createCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Fake MDS Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	intermediate, err := createCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Fake MDS CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root, &intermediateKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := createCertificate(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Fake MDS Signer"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, intermediate, &key.PublicKey, intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetadataService{Root: root.Raw, chain: [][]byte{leaf.Raw, intermediate.Raw}, key: key}, nil
+}
+
+// Blob returns an MDS3 blob, a JWT signed with ES256, with the entries
+func (m *MetadataService) Blob(entries ...MetadataEntry) ([]byte, error) {
+	today := time.Now().Format(time.DateOnly)
+	var list []map[string]any
+	for _, e := range entries {
+		aaguid := uuid.UUID(e.Attestation.AAGUID).String()
+		list = append(list, map[string]any{
+			"aaguid": aaguid,
+			"metadataStatement": map[string]any{
+				"aaguid":                      aaguid,
+				"description":                 "Fake Authenticator",
+				"protocolFamily":              "fido2",
+				"schema":                      3,
+				"attestationTypes":            []string{"basic_full"},
+				"attestationRootCertificates": []string{base64.StdEncoding.EncodeToString(e.Attestation.Root)},
+			},
+			"statusReports":          []map[string]any{{"status": e.Status, "effectiveDate": today}},
+			"timeOfLastStatusChange": today,
+		})
+	}
+
+	var x5c []string
+	for _, c := range m.chain {
+		x5c = append(x5c, base64.StdEncoding.EncodeToString(c))
+	}
+	header, err := json.Marshal(map[string]any{"alg": "ES256", "typ": "JWT", "x5c": x5c})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"legalHeader": "Fake metadata for tests",
+		"no":          1,
+		"nextUpdate":  time.Now().AddDate(0, 1, 0).Format(time.DateOnly),
+		"entries":     list,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, m.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	// JWS signatures are the raw r and s, not ASN.1
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return []byte(signed + "." + b64.EncodeToString(sig)), nil
+}
+
+func createCertificate(template *x509.Certificate, parent *x509.Certificate, pub *ecdsa.PublicKey, key *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(1, 0, 0)
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,123 @@
+package fakes
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// SMTPFake is a minimal SMTP server that accepts all mail and keeps the received messages in memory
+type SMTPFake struct {
+	mut      sync.Mutex
+	listener net.Listener
+	Addr     string
+	Messages map[string][]string // Received message data by recipient
+}
+
+func CreateSMTPFake() *SMTPFake {
+	return &SMTPFake{
+		Addr:     "127.0.0.1:8997",
+		Messages: map[string][]string{},
+	}
+}
+
+func (fake *SMTPFake) Start() error {
+	l, err := net.Listen("tcp", fake.Addr)
+	if err != nil {
+		return err
+	}
+	fake.mut.Lock()
+	fake.listener = l
+	fake.mut.Unlock()
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go fake.handle(conn)
+	}
+}
+
+func (fake *SMTPFake) Stop() error {
+	fake.mut.Lock()
+	defer fake.mut.Unlock()
+	if fake.listener == nil {
+		return nil
+	}
+	return fake.listener.Close()
+}
+
+// Last returns the last message received for the recipient
+func (fake *SMTPFake) Last(rcpt string) string {
+	fake.mut.Lock()
+	defer fake.mut.Unlock()
+	msgs := fake.Messages[rcpt]
+	if len(msgs) == 0 {
+		return ""
+	}
+	return msgs[len(msgs)-1]
+}
+
+func (fake *SMTPFake) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	var rcpts []string
+	_ = tp.PrintfLine("220 fake smtp")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			_ = tp.PrintfLine("250 fake smtp")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			rcpts = nil
+			_ = tp.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpts = append(rcpts, strings.Trim(line[len("RCPT TO:"):], " <>"))
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "DATA":
+			_ = tp.PrintfLine("354 Go ahead")
+			data, err := readData(tp.R)
+			if err != nil {
+				return
+			}
+			fake.mut.Lock()
+			for _, r := range rcpts {
+				fake.Messages[r] = append(fake.Messages[r], data)
+			}
+			fake.mut.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		case cmd == "RSET", cmd == "NOOP":
+			_ = tp.PrintfLine("250 OK")
+		default:
+			_ = tp.PrintfLine("502 %s not implemented", line)
+		}
+	}
+}
+
+func readData(r *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return sb.String(), nil
+		}
+		sb.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
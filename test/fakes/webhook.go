@@ -0,0 +1,72 @@
+package fakes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebhookFake records the JSON bodies posted to it
+type WebhookFake struct {
+	mut    sync.Mutex
+	server *http.Server
+	URL    string
+	Bodies []map[string]any
+}
+
+func CreateWebhookFake() *WebhookFake {
+	mux := http.ServeMux{}
+	fake := WebhookFake{
+		server: &http.Server{
+			Addr:    ":8996",
+			Handler: &mux,
+		},
+		URL: "http://127.0.0.1:8996/hook",
+	}
+	mux.HandleFunc("/hook", fake.handle)
+	return &fake
+}
+
+func (fake *WebhookFake) Start() error {
+	return fake.server.ListenAndServe()
+}
+
+func (fake *WebhookFake) Stop(deadline time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	return fake.server.Shutdown(ctx)
+}
+
+// Last returns the last body received for a destination
+func (fake *WebhookFake) Last(destination string) map[string]any {
+	fake.mut.Lock()
+	defer fake.mut.Unlock()
+	for i := len(fake.Bodies) - 1; i >= 0; i-- {
+		if fake.Bodies[i]["destination"] == destination {
+			return fake.Bodies[i]
+		}
+	}
+	return nil
+}
+
+func (fake *WebhookFake) handle(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var body map[string]any
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fake.mut.Lock()
+	fake.Bodies = append(fake.Bodies, body)
+	fake.mut.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -12,14 +12,24 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/modfin/twofer/internal/bankid"
+	"github.com/modfin/twofer/internal/config"
 	"github.com/modfin/twofer/internal/httpserve"
 	"github.com/modfin/twofer/internal/ordertoken"
+	"github.com/modfin/twofer/internal/replay"
+	"github.com/modfin/twofer/internal/servoob"
+	"github.com/modfin/twofer/internal/servotp"
+	"github.com/modfin/twofer/internal/servw6n"
 	"github.com/modfin/twofer/stream/sse"
 	"github.com/modfin/twofer/test/fakes"
 	"github.com/stretchr/testify/suite"
@@ -31,6 +41,13 @@ type IntegrationTestSuite struct {
 	twoferURL string
 
 	bankidv6 *fakes.BankIDV6Fake
+	smtp     *fakes.SMTPFake
+	webhook  *fakes.WebhookFake
+
+	// Authenticator models in the WebAuthn metadata blob
+	certified   *fakes.Attestation
+	revoked     *fakes.Attestation
+	uncertified *fakes.Attestation
 }
 
 func (s *IntegrationTestSuite) SetupSuite() {
@@ -46,8 +63,44 @@ func (s *IntegrationTestSuite) SetupSuite() {
 		}
 	}()
 
+	// SMTP and webhook, used for out-of-band codes
+	s.smtp = fakes.CreateSMTPFake()
+	go func() {
+		slog.Info("Starting smtp fake")
+		err := s.smtp.Start()
+		if err != nil {
+			fmt.Println("Error starting smtp server.", err.Error())
+		}
+	}()
+	s.webhook = fakes.CreateWebhookFake()
+	go func() {
+		slog.Info("Starting webhook fake")
+		err := s.webhook.Start()
+		if err != nil && !errors.Is(http.ErrServerClosed, err) {
+			fmt.Println("Error starting webhook server.", err.Error())
+		}
+	}()
+
+	// WebAuthn metadata
+	mds, err := fakes.NewMetadataService()
+	s.Require().NoError(err)
+	s.certified, err = fakes.NewAttestation(uuid.New())
+	s.Require().NoError(err)
+	s.revoked, err = fakes.NewAttestation(uuid.New())
+	s.Require().NoError(err)
+	s.uncertified, err = fakes.NewAttestation(uuid.New())
+	s.Require().NoError(err)
+	blob, err := mds.Blob(
+		fakes.MetadataEntry{Attestation: s.certified, Status: "FIDO_CERTIFIED_L1"},
+		fakes.MetadataEntry{Attestation: s.revoked, Status: "REVOKED"},
+		fakes.MetadataEntry{Attestation: s.uncertified, Status: "NOT_FIDO_CERTIFIED"},
+	)
+	s.Require().NoError(err)
+	mdsBlob := filepath.Join(s.T().TempDir(), "mds.jwt")
+	s.Require().NoError(os.WriteFile(mdsBlob, blob, 0600))
+
 	//TWOFER
-	app, err := InitApplication(s.bankidv6.URL)
+	app, err := InitApplication(s.bankidv6.URL, s.smtp.Addr, s.webhook.URL, mdsBlob, base64.StdEncoding.EncodeToString(mds.Root))
 	if err != nil {
 		fmt.Println("Error setting up twofer in SetupSuite", err)
 	}
@@ -76,9 +129,21 @@ func (s *IntegrationTestSuite) TearDownSuite() {
 		}
 	}()
 
+	go func() {
+		err := s.webhook.Stop(d)
+		if err != nil {
+			fmt.Println("Error stopping webhook server.", err.Error())
+		}
+		err = s.smtp.Stop()
+		if err != nil {
+			fmt.Println("Error stopping smtp server.", err.Error())
+		}
+	}()
+
 	go func() {
 		parentCtx := context.Background()
-		ctx, _ := context.WithTimeout(parentCtx, d)
+		ctx, cancel := context.WithTimeout(parentCtx, d)
+		defer cancel()
 		err := s.twofer.Shutdown(ctx)
 		if err != nil {
 			fmt.Println("Error stopping twofer server.", err.Error())
@@ -91,7 +156,10 @@ func TestInit(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
 
-func InitApplication(bankIDV6URL string) (*echo.Echo, error) {
+// otpKeys are fixed, rather than generated, so that tests can create blobs sealed with the old key to upgrade
+var otpKeys = []string{"1:aes:uTdWcGl+cOnIgHoGnuBF3w==", "2:aes:Hg44JefQsFJMI1F0zhWMpw=="}
+
+func InitApplication(bankIDV6URL string, smtpAddr string, webhookURL string, mdsBlob string, mdsRoot string) (*echo.Echo, error) {
 	e := echo.New()
 
 	client := &http.Client{}
@@ -112,6 +180,54 @@ func InitApplication(bankIDV6URL string) (*echo.Echo, error) {
 	twoferBankIDAPI := bankid.NewAPI(client, bankIDV6URL, time.Second)
 	httpserve.RegisterBankIDServer(e, twoferBankIDAPI, otm, sse.NewEncoder)
 
+	otp, err := servotp.New(servotp.OTPConfig{RateLimit: 100}, otpKeys)
+	if err != nil {
+		return nil, fmt.Errorf("error creating otp server: %v", err)
+	}
+	httpserve.RegisterOTPServer(e, otp)
+
+	smtpHost, smtpPort, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(smtpPort)
+	if err != nil {
+		return nil, err
+	}
+	smtpSender, err := servoob.NewSMTPSender(servoob.SMTPConfig{Host: smtpHost, Port: port, From: "twofer@example.com"})
+	if err != nil {
+		return nil, fmt.Errorf("error creating smtp sender: %v", err)
+	}
+	webhookSender, err := servoob.NewWebhookSender(servoob.WebhookConfig{URL: webhookURL, Secret: "secret"})
+	if err != nil {
+		return nil, fmt.Errorf("error creating webhook sender: %v", err)
+	}
+	oob, err := servoob.New(servoob.OOBConfig{MaxAttempts: 3, RateLimit: 100}, []string{fmt.Sprintf("1:aes:%s", key)}, map[string]servoob.Sender{
+		"email": smtpSender,
+		"sms":   webhookSender,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating oob server: %v", err)
+	}
+	httpserve.RegisterOOBServer(e, oob)
+
+	authn, err := servw6n.New(config.WebAuthn{
+		RPDisplayName:    "Twofer",
+		RPID:             webAuthnRPID,
+		RPOrigin:         webAuthnOrigin,
+		HMACKey:          string(key),
+		EncryptionKey:    []string{fmt.Sprintf("1:aes:%s", key)},
+		UserVerification: "discouraged",
+		MDSBlob:          mdsBlob,
+		MDSRoot:          mdsRoot,
+		RateLimit:        100,
+		Timeout:          time.Minute,
+	}, replay.NewMemory())
+	if err != nil {
+		return nil, fmt.Errorf("error creating webauthn server: %v", err)
+	}
+	httpserve.RegisterWebAuthnServer(e, authn)
+
 	return e, nil
 }
 
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/modfin/twofer"
+	"github.com/modfin/twofer/internal/servoob"
+)
+
+var codeRegexp = regexp.MustCompile(`verification code is ([0-9]+)`)
+
+func (s *IntegrationTestSuite) TestOOBEmail() {
+	ctx := context.Background()
+	client := twofer.NewOobClient(s.twoferURL)
+
+	challenge, err := client.Challenge(ctx, &servoob.ChallengeReq{Channel: "email", Destination: "john@example.com"})
+	s.Require().NoError(err)
+	s.Require().NotEmpty(challenge.Challenge)
+
+	match := codeRegexp.FindStringSubmatch(s.smtp.Last("john@example.com"))
+	s.Require().Len(match, 2, "no code found in email")
+
+	res, err := client.Verify(ctx, &servoob.VerifyReq{Code: "000000" + match[1], Challenge: challenge.Challenge})
+	s.Require().NoError(err)
+	s.False(res.Valid)
+	s.Equal(uint32(2), res.Remaining)
+	s.Require().NotEmpty(res.Challenge)
+
+	res, err = client.Verify(ctx, &servoob.VerifyReq{Code: match[1], Challenge: res.Challenge})
+	s.Require().NoError(err)
+	s.True(res.Valid)
+	s.Equal("john@example.com", res.Destination)
+	s.Equal("email", res.Channel)
+}
+
+func (s *IntegrationTestSuite) TestOOBWebhook() {
+	ctx := context.Background()
+	client := twofer.NewOobClient(s.twoferURL)
+
+	challenge, err := client.Challenge(ctx, &servoob.ChallengeReq{Channel: "sms", Destination: "+46700000000"})
+	s.Require().NoError(err)
+
+	body := s.webhook.Last("+46700000000")
+	s.Require().NotNil(body, "no webhook received")
+	code, ok := body["code"].(string)
+	s.Require().True(ok)
+
+	res, err := client.Verify(ctx, &servoob.VerifyReq{Code: code, Challenge: challenge.Challenge})
+	s.Require().NoError(err)
+	s.True(res.Valid)
+	s.Equal("+46700000000", res.Destination)
+}
+
+func (s *IntegrationTestSuite) TestOOBAttempts() {
+	ctx := context.Background()
+	client := twofer.NewOobClient(s.twoferURL)
+
+	challenge, err := client.Challenge(ctx, &servoob.ChallengeReq{Channel: "sms", Destination: "+46700000001"})
+	s.Require().NoError(err)
+
+	blob := challenge.Challenge
+	for i := 0; i < 3; i++ {
+		res, err := client.Verify(ctx, &servoob.VerifyReq{Code: "x", Challenge: blob})
+		s.Require().NoError(err)
+		s.False(res.Valid)
+		blob = res.Challenge
+	}
+	s.Empty(blob, "no challenge should be returned once all attempts are used")
+
+	_, err = client.Challenge(ctx, &servoob.ChallengeReq{Channel: "fax", Destination: "+46700000001"})
+	s.Error(err, "expected unsupported channel to be rejected")
+}
+
+func (s *IntegrationTestSuite) TestOOBReplay() {
+	ctx := context.Background()
+	client := twofer.NewOobClient(s.twoferURL)
+
+	challenge, err := client.Challenge(ctx, &servoob.ChallengeReq{Channel: "email", Destination: "jane@example.com"})
+	s.Require().NoError(err)
+	match := codeRegexp.FindStringSubmatch(s.smtp.Last("jane@example.com"))
+	s.Require().Len(match, 2, "no code found in email")
+
+	// Replaying the original challenge doesn't reset the attempt counter
+	res, err := client.Verify(ctx, &servoob.VerifyReq{Code: "x", Challenge: challenge.Challenge})
+	s.Require().NoError(err)
+	s.False(res.Valid)
+	_, err = client.Verify(ctx, &servoob.VerifyReq{Code: "x", Challenge: challenge.Challenge})
+	s.Error(err, "expected a replayed challenge to be rejected")
+
+	res, err = client.Verify(ctx, &servoob.VerifyReq{Code: match[1], Challenge: res.Challenge})
+	s.Require().NoError(err)
+	s.True(res.Valid)
+
+	// A verified code can't be verified again
+	_, err = client.Verify(ctx, &servoob.VerifyReq{Code: match[1], Challenge: challenge.Challenge})
+	s.Error(err, "expected a verified challenge to be rejected")
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/modfin/twofer"
+	"github.com/modfin/twofer/internal/servotp"
+)
+
+const otpSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func (s *IntegrationTestSuite) TestOTPImportBulk() {
+	ctx := context.Background()
+	client := twofer.NewOtpClient(s.twoferURL)
+
+	results, err := client.ImportBulk(ctx, []servotp.Import{
+		{Ref: "john", Secret: otpSecret, Issuer: "ACME", Account: "john@example.com"},
+		{Ref: "jane", Secret: "not base32!", Issuer: "ACME", Account: "jane@example.com"},
+		{Ref: "joe", Uri: "otpauth://totp/ACME:joe@example.com?secret=" + otpSecret + "&issuer=ACME"},
+	})
+	s.Require().NoError(err)
+	s.Require().Len(results, 3)
+	for i, ref := range []string{"john", "jane", "joe"} {
+		s.Equal(ref, results[i].Ref)
+		s.Equal(i+1, results[i].Line)
+	}
+	s.NotEmpty(results[0].UserBlob)
+	s.Empty(results[0].Error)
+	s.Empty(results[1].UserBlob)
+	s.NotEmpty(results[1].Error, "expected an invalid secret to be reported on its line")
+	s.NotEmpty(results[2].UserBlob)
+	s.Empty(results[2].Error)
+
+	// A malformed line ends the stream, the lines before it are still processed
+	body := strings.Join([]string{
+		fmt.Sprintf(`{"ref":"john","secret":%q,"issuer":"ACME","account":"john@example.com"}`, otpSecret),
+		`{"ref":"jane","secret":`,
+		fmt.Sprintf(`{"ref":"joe","secret":%q,"issuer":"ACME","account":"joe@example.com"}`, otpSecret),
+	}, "\n")
+	results = s.postBulk("/v1/otp/import/bulk", body)
+	s.Require().Len(results, 2)
+	s.Equal("john", results[0].Ref)
+	s.NotEmpty(results[0].UserBlob)
+	s.Equal(2, results[1].Line)
+	s.Contains(results[1].Error, "invalid json")
+}
+
+func (s *IntegrationTestSuite) TestOTPUpgradeBulk() {
+	ctx := context.Background()
+	client := twofer.NewOtpClient(s.twoferURL)
+
+	// A blob sealed with the old key only, as it was before the new key was added
+	old, err := servotp.New(servotp.OTPConfig{}, otpKeys[:1])
+	s.Require().NoError(err)
+	imported, err := old.Import(ctx, &servotp.Import{Secret: otpSecret, Issuer: "ACME", Account: "john@example.com"})
+	s.Require().NoError(err)
+
+	results, err := client.UpgradeBulk(ctx, []servotp.Blob{
+		{Ref: "john", UserBlob: imported.UserBlob},
+		{Ref: "jane", UserBlob: "bm90IGEgYmxvYg=="},
+	})
+	s.Require().NoError(err)
+	s.Require().Len(results, 2)
+	s.Equal("john", results[0].Ref)
+	s.Empty(results[0].Error)
+	s.Require().NotEmpty(results[0].UserBlob)
+	s.NotEqual(imported.UserBlob, results[0].UserBlob)
+	s.Equal("jane", results[1].Ref)
+	s.Equal(2, results[1].Line)
+	s.NotEmpty(results[1].Error, "expected a blob that can't be decrypted to be reported on its line")
+
+	// The upgraded blob is sealed with the new key, which the old server doesn't have
+	_, err = old.Upgrade(ctx, &servotp.Blob{UserBlob: results[0].UserBlob})
+	s.Error(err)
+}
+
+// postBulk posts a raw NDJSON body to path and decodes the results
+func (s *IntegrationTestSuite) postBulk(path string, body string) []servotp.BulkResult {
+	resp, err := http.Post(s.twoferURL+path, "application/x-json-stream", strings.NewReader(body))
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	var results []servotp.BulkResult
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var res servotp.BulkResult
+		err = dec.Decode(&res)
+		if errors.Is(err, io.EOF) {
+			return results
+		}
+		s.Require().NoError(err)
+		results = append(results, res)
+	}
+}
@@ -0,0 +1,607 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/modfin/twofer"
+	"github.com/modfin/twofer/internal/servw6n"
+	"github.com/modfin/twofer/test/fakes"
+)
+
+const (
+	webAuthnRPID   = "localhost"
+	webAuthnOrigin = "http://localhost:8999"
+)
+
+// enrollWebAuthn enrolls a new credential on the authenticator and returns the userBlob
+func (s *IntegrationTestSuite) enrollWebAuthn(client *twofer.WebAuthnClient, authenticator *fakes.Authenticator, userBlob []byte) []byte {
+	ctx := context.Background()
+	init, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{
+		User:     &servw6n.EnrollUser{Id: "user-1", Name: "john"},
+		UserBlob: userBlob,
+	})
+	s.Require().NoError(err)
+	s.Require().NotEmpty(init.Session)
+
+	signature, err := authenticator.Create(init.Json)
+	s.Require().NoError(err)
+
+	final, err := client.EnrollFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.Require().NotEmpty(final.UserBlob)
+	return final.UserBlob
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnEnrollAndAuth() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.True(final.Valid)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnMultipleAuthenticators() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	first := fakes.NewAuthenticator(webAuthnOrigin)
+	second := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, first, nil)
+	userBlob = s.enrollWebAuthn(client, second, userBlob)
+
+	for _, authenticator := range []*fakes.Authenticator{first, second} {
+		init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+		s.Require().NoError(err)
+		signature, err := authenticator.Get(init.Json)
+		s.Require().NoError(err)
+		final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+		s.Require().NoError(err)
+		s.True(final.Valid)
+	}
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnWrongOrigin() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+
+	// A phishing site relaying the challenge gets a signature bound to its own origin
+	authenticator.Origin = "https://phishing.example"
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.False(final.Valid)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnUnknownCredential() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	// A credential that the user doesn't own is a bad request, not an invalid signature
+	var response map[string]any
+	s.Require().NoError(json.Unmarshal(signature, &response))
+	id := base64.RawURLEncoding.EncodeToString([]byte("not a credential of the user"))
+	response["id"], response["rawId"] = id, id
+	signature, err = json.Marshal(response)
+	s.Require().NoError(err)
+
+	_, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().Error(err)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnTamperedSession() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	session := append([]byte{}, init.Session...)
+	session[0] ^= 1
+	_, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: session, Signature: signature})
+	s.Require().Error(err)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnSealedUserBlob() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+	s.NotContains(string(userBlob), "user-1", "expected userBlob to be encrypted")
+
+	// A blob that isn't sealed by twofer, eg. with an injected credential, is rejected
+	_, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: []byte(`{"id":"user-1","credentials":[]}`)})
+	s.Require().Error(err)
+
+	upgraded, err := client.Upgrade(ctx, &servw6n.Blob{UserBlob: userBlob})
+	s.Require().NoError(err)
+	s.Require().NotEmpty(upgraded.UserBlob)
+	s.NotEqual(userBlob, upgraded.UserBlob)
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: upgraded.UserBlob})
+	s.Require().NoError(err)
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.True(final.Valid)
+}
+
+// authWebAuthn makes a login with the authenticator and returns the result
+func (s *IntegrationTestSuite) authWebAuthn(client *twofer.WebAuthnClient, authenticator *fakes.Authenticator, userBlob []byte) servw6n.FinalRes {
+	ctx := context.Background()
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+	signature, err := authenticator.Get(init.Json)
+	s.Require().NoError(err)
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	return final
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnSignCount() {
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+	userBlob := s.enrollWebAuthn(client, authenticator, nil)
+
+	// The clone is taken before the original is used
+	clone := authenticator.Clone()
+
+	for i := 0; i < 2; i++ {
+		res := s.authWebAuthn(client, authenticator, userBlob)
+		s.Require().True(res.Valid)
+		s.False(res.CloneWarning)
+		s.Require().NotEmpty(res.UserBlob)
+		userBlob = res.UserBlob
+	}
+
+	// The clone signs with a count that is lower than the persisted one
+	res := s.authWebAuthn(client, clone, userBlob)
+	s.True(res.Valid)
+	s.True(res.CloneWarning)
+	userBlob = res.UserBlob
+
+	// The warning sticks to the credential
+	res = s.authWebAuthn(client, authenticator, userBlob)
+	s.True(res.Valid)
+	s.True(res.CloneWarning)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnDiscoverable() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	enroll, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{
+		User: &servw6n.EnrollUser{Id: "user-1", Name: "john"},
+		Cfg: &servw6n.Config{
+			RPID:          webAuthnRPID,
+			RPDisplayName: "twofer",
+			RPOrigin:      webAuthnOrigin,
+			ResidentKey:   "required",
+		},
+	})
+	s.Require().NoError(err)
+	s.Contains(string(enroll.Json), `"residentKey":"required"`)
+	signature, err := authenticator.Create(enroll.Json)
+	s.Require().NoError(err)
+	enrolled, err := client.EnrollFinal(ctx, &servw6n.FinalReq{Session: enroll.Session, Signature: signature})
+	s.Require().NoError(err)
+	userBlob := enrolled.UserBlob
+
+	// Without a userBlob no credentials are allowed, and the authenticator picks a resident one
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{})
+	s.Require().NoError(err)
+	s.NotContains(string(init.Json), "allowCredentials")
+	signature, err = authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.False(final.Valid)
+	s.Equal("user-1", final.UserHandle)
+
+	// The blob of another user can't be used to validate the signature
+	other, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-2", Name: "jane"}})
+	s.Require().NoError(err)
+	otherSignature, err := fakes.NewAuthenticator(webAuthnOrigin).Create(other.Json)
+	s.Require().NoError(err)
+	otherEnrolled, err := client.EnrollFinal(ctx, &servw6n.FinalReq{Session: other.Session, Signature: otherSignature})
+	s.Require().NoError(err)
+	_, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, UserBlob: otherEnrolled.UserBlob})
+	s.Require().Error(err)
+
+	final, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, UserBlob: userBlob})
+	s.Require().NoError(err)
+	s.True(final.Valid)
+	s.Equal("user-1", final.UserHandle)
+	s.NotEmpty(final.UserBlob)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnCredentials() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	kept := fakes.NewAuthenticator(webAuthnOrigin)
+	lost := fakes.NewAuthenticator(webAuthnOrigin)
+
+	userBlob := s.enrollWebAuthn(client, kept, nil)
+	userBlob = s.enrollWebAuthn(client, lost, userBlob)
+
+	list, err := client.Credentials(ctx, &servw6n.Blob{UserBlob: userBlob})
+	s.Require().NoError(err)
+	s.Require().Len(list.Credentials, 2)
+	s.Equal(kept.Credentials()[0].ID, list.Credentials[0].ID)
+	s.Equal(webAuthnRPID, list.Credentials[0].RPID)
+	s.Equal("00000000-0000-0000-0000-000000000000", list.Credentials[0].AAGUID)
+	s.NotZero(list.Credentials[0].Created)
+
+	renamed, err := client.RenameCredential(ctx, &servw6n.RenameReq{
+		UserBlob: userBlob,
+		ID:       kept.Credentials()[0].ID,
+		Nickname: "keychain",
+	})
+	s.Require().NoError(err)
+	removed, err := client.RemoveCredential(ctx, &servw6n.RemoveReq{
+		UserBlob: renamed.UserBlob,
+		ID:       lost.Credentials()[0].ID,
+	})
+	s.Require().NoError(err)
+	userBlob = removed.UserBlob
+
+	list, err = client.Credentials(ctx, &servw6n.Blob{UserBlob: userBlob})
+	s.Require().NoError(err)
+	s.Require().Len(list.Credentials, 1)
+	s.Equal("keychain", list.Credentials[0].Nickname)
+
+	// Removing a credential that isn't in the blob is an error
+	_, err = client.RemoveCredential(ctx, &servw6n.RemoveReq{UserBlob: userBlob, ID: lost.Credentials()[0].ID})
+	s.Require().Error(err)
+
+	// The removed credential is no longer allowed, while the kept one still works
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob})
+	s.Require().NoError(err)
+	_, err = lost.Get(init.Json)
+	s.Require().Error(err)
+	signature, err := kept.Get(init.Json)
+	s.Require().NoError(err)
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.True(final.Valid)
+}
+
+// enrollWebAuthnCfg enrolls a new credential on the authenticator with the request config
+func (s *IntegrationTestSuite) enrollWebAuthnCfg(client *twofer.WebAuthnClient, authenticator *fakes.Authenticator, cfg *servw6n.Config) (servw6n.FinalRes, error) {
+	ctx := context.Background()
+	init, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{
+		User: &servw6n.EnrollUser{Id: "user-1", Name: "john"},
+		Cfg:  cfg,
+	})
+	s.Require().NoError(err)
+
+	signature, err := authenticator.Create(init.Json)
+	s.Require().NoError(err)
+
+	return client.EnrollFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, Cfg: cfg})
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnMetadata() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	cfg := &servw6n.Config{
+		RPID:            webAuthnRPID,
+		RPDisplayName:   "Twofer",
+		RPOrigin:        webAuthnOrigin,
+		RequireMetadata: true,
+	}
+
+	init, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-1"}, Cfg: cfg})
+	s.Require().NoError(err)
+	s.Contains(string(init.Json), `"attestation":"direct"`)
+
+	certified := fakes.NewAuthenticator(webAuthnOrigin)
+	certified.Attestation = s.certified
+	final, err := s.enrollWebAuthnCfg(client, certified, cfg)
+	s.Require().NoError(err)
+	s.NotEmpty(final.UserBlob)
+
+	unknown, err := fakes.NewAttestation(uuid.New())
+	s.Require().NoError(err)
+	// A vendor root that isn't the one in the metadata of the model
+	impostor, err := fakes.NewAttestation(s.certified.AAGUID)
+	s.Require().NoError(err)
+
+	for name, attestation := range map[string]*fakes.Attestation{
+		"revoked":     s.revoked,
+		"uncertified": s.uncertified,
+		"unknown":     unknown,
+		"impostor":    impostor,
+		"none":        nil,
+	} {
+		authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+		authenticator.Attestation = attestation
+		_, err = s.enrollWebAuthnCfg(client, authenticator, cfg)
+		s.Error(err, name)
+	}
+
+	// Without required metadata any authenticator may enroll
+	cfg.RequireMetadata = false
+	_, err = s.enrollWebAuthnCfg(client, fakes.NewAuthenticator(webAuthnOrigin), cfg)
+	s.Require().NoError(err)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnAAGUIDLists() {
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	allowed := fakes.NewAuthenticator(webAuthnOrigin)
+	allowed.AAGUID = uuid.New()
+	other := fakes.NewAuthenticator(webAuthnOrigin)
+	other.AAGUID = uuid.New()
+
+	cfg := &servw6n.Config{
+		RPID:          webAuthnRPID,
+		RPDisplayName: "Twofer",
+		RPOrigin:      webAuthnOrigin,
+		AAGUIDAllow:   []string{uuid.UUID(allowed.AAGUID).String()},
+	}
+	_, err := s.enrollWebAuthnCfg(client, allowed, cfg)
+	s.Require().NoError(err)
+	_, err = s.enrollWebAuthnCfg(client, other, cfg)
+	s.Require().Error(err)
+
+	cfg.AAGUIDAllow = nil
+	cfg.AAGUIDDeny = []string{uuid.UUID(allowed.AAGUID).String()}
+	_, err = s.enrollWebAuthnCfg(client, allowed, cfg)
+	s.Require().Error(err)
+	_, err = s.enrollWebAuthnCfg(client, other, cfg)
+	s.Require().NoError(err)
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnOrigins() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	const android = "android:apk-key-hash:fJwBn-Lt-Ct4i4O5-Ia9hyXSZq7PzGFD9y7bo0hOYjA"
+	cfg := &servw6n.Config{
+		RPID:          webAuthnRPID,
+		RPDisplayName: "Twofer",
+		RPOrigins:     []string{webAuthnOrigin, "http://app.localhost:8999", android},
+		RPTopOrigins:  []string{"https://partner.example"},
+	}
+
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+	enrolled, err := s.enrollWebAuthnCfg(client, authenticator, cfg)
+	s.Require().NoError(err)
+
+	auth := func() servw6n.FinalRes {
+		init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: enrolled.UserBlob, Cfg: cfg})
+		s.Require().NoError(err)
+		signature, err := authenticator.Get(init.Json)
+		s.Require().NoError(err)
+		final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, Cfg: cfg})
+		s.Require().NoError(err)
+		return final
+	}
+
+	for _, origin := range []string{"http://app.localhost:8999", android} {
+		authenticator.Origin = origin
+		s.True(auth().Valid, origin)
+	}
+	authenticator.Origin = "http://other.localhost:8999"
+	s.False(auth().Valid)
+
+	// Embedded in an iframe, by a top origin or by one of the RP origins
+	authenticator.Origin = webAuthnOrigin
+	for _, top := range []string{"https://partner.example", "http://app.localhost:8999"} {
+		authenticator.TopOrigin = top
+		s.True(auth().Valid, top)
+	}
+	authenticator.TopOrigin = "https://phishing.example"
+	s.False(auth().Valid)
+	authenticator.TopOrigin = ""
+
+	// Origins outside of the rp id, or without https, are not accepted in any of the calls
+	for _, origin := range []string{"https://evil.example", "ftp://localhost", "http://localhost.example"} {
+		bad := *cfg
+		bad.RPOrigins = []string{origin}
+		_, err = client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-1"}, Cfg: &bad})
+		s.Error(err, origin)
+		_, err = client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: enrolled.UserBlob, Cfg: &bad})
+		s.Error(err, origin)
+
+		init, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-1"}, Cfg: cfg})
+		s.Require().NoError(err)
+		signature, err := fakes.NewAuthenticator(webAuthnOrigin).Create(init.Json)
+		s.Require().NoError(err)
+		_, err = client.EnrollFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, Cfg: &bad})
+		s.Error(err, origin)
+
+		init, err = client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: enrolled.UserBlob, Cfg: cfg})
+		s.Require().NoError(err)
+		signature, err = authenticator.Get(init.Json)
+		s.Require().NoError(err)
+		_, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature, Cfg: &bad})
+		s.Error(err, origin)
+	}
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnSessions() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+
+	enroll, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-1", Name: "john"}})
+	s.Require().NoError(err)
+	// The session is encrypted, the user and the challenge are not readable by the caller
+	payload, _, _ := bytes.Cut(enroll.Session, []byte("."))
+	raw, err := base64.StdEncoding.DecodeString(string(payload))
+	s.Require().NoError(err)
+	s.False(json.Valid(raw))
+	signature, err := authenticator.Create(enroll.Json)
+	s.Require().NoError(err)
+	enrolled, err := client.EnrollFinal(ctx, &servw6n.FinalReq{Session: enroll.Session, Signature: signature})
+	s.Require().NoError(err)
+	_, err = client.EnrollFinal(ctx, &servw6n.FinalReq{Session: enroll.Session, Signature: signature})
+	s.Require().Error(err, "expected an enroll session to be single use")
+
+	init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: enrolled.UserBlob})
+	s.Require().NoError(err)
+	signature, err = authenticator.Get(init.Json)
+	s.Require().NoError(err)
+
+	// A session can only be finalized by the call it was created for
+	_, err = client.EnrollFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().Error(err)
+
+	final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().NoError(err)
+	s.True(final.Valid)
+	_, err = client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+	s.Require().Error(err, "expected an auth session to be single use")
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnExtensions() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+	authenticator := fakes.NewAuthenticator(webAuthnOrigin)
+	authenticator.PRF = true
+	authenticator.LargeBlob = true
+	legacy := fakes.NewAuthenticator(webAuthnOrigin)
+
+	extensions := &servw6n.Extensions{
+		PRF:       &servw6n.PRFInputs{},
+		LargeBlob: &servw6n.LargeBlobInputs{Support: "preferred"},
+	}
+	enroll := func(authenticator *fakes.Authenticator, userBlob []byte) servw6n.FinalRes {
+		init, err := client.EnrollInit(ctx, &servw6n.EnrollInitReq{
+			User:       &servw6n.EnrollUser{Id: "user-3", Name: "jane"},
+			UserBlob:   userBlob,
+			Extensions: extensions,
+		})
+		s.Require().NoError(err)
+		s.Contains(string(init.Json), `"prf"`)
+		signature, err := authenticator.Create(init.Json)
+		s.Require().NoError(err)
+		final, err := client.EnrollFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+		s.Require().NoError(err)
+		return final
+	}
+	enrolled := enroll(authenticator, nil)
+	s.Require().NotNil(enrolled.Extensions)
+	s.True(enrolled.Extensions.PRF.Enabled)
+	s.True(enrolled.Extensions.LargeBlob.Supported)
+	enrolled = enroll(legacy, enrolled.UserBlob)
+	s.Nil(enrolled.Extensions)
+	userBlob := enrolled.UserBlob
+
+	// The capabilities are recorded in the credentials of the blob
+	list, err := client.Credentials(ctx, &servw6n.Blob{UserBlob: userBlob})
+	s.Require().NoError(err)
+	s.Require().Len(list.Credentials, 2)
+	s.True(list.Credentials[0].PRF)
+	s.True(list.Credentials[0].LargeBlob)
+	s.False(list.Credentials[1].PRF)
+	s.False(list.Credentials[1].LargeBlob)
+
+	auth := func(extensions *servw6n.Extensions) servw6n.FinalRes {
+		init, err := client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob, Extensions: extensions})
+		s.Require().NoError(err)
+		signature, err := authenticator.Get(init.Json)
+		s.Require().NoError(err)
+		final, err := client.AuthFinal(ctx, &servw6n.FinalReq{Session: init.Session, Signature: signature})
+		s.Require().NoError(err)
+		s.Require().True(final.Valid)
+		userBlob = final.UserBlob
+		return final
+	}
+
+	// The prf is deterministic per credential and salt
+	salt := &servw6n.Extensions{PRF: &servw6n.PRFInputs{Eval: &servw6n.PRFValues{First: []byte("notes-key"), Second: []byte("next-key")}}}
+	first := auth(salt)
+	s.Require().NotNil(first.Extensions)
+	s.Require().NotNil(first.Extensions.PRF.Results)
+	s.Len(first.Extensions.PRF.Results.First, 32)
+	s.NotEqual(first.Extensions.PRF.Results.First, first.Extensions.PRF.Results.Second)
+	s.Equal(first.Extensions.PRF.Results, auth(salt).Extensions.PRF.Results)
+	other := auth(&servw6n.Extensions{PRF: &servw6n.PRFInputs{Eval: &servw6n.PRFValues{First: []byte("other")}}})
+	s.NotEqual(first.Extensions.PRF.Results.First, other.Extensions.PRF.Results.First)
+
+	// Salts by credential take precedence over eval
+	byCredential := auth(&servw6n.Extensions{PRF: &servw6n.PRFInputs{
+		Eval: &servw6n.PRFValues{First: []byte("other")},
+		EvalByCredential: map[string]servw6n.PRFValues{
+			base64.RawURLEncoding.EncodeToString(authenticator.Credentials()[0].ID): {First: []byte("notes-key")},
+		},
+	}})
+	s.Equal(first.Extensions.PRF.Results.First, byCredential.Extensions.PRF.Results.First)
+
+	written := auth(&servw6n.Extensions{LargeBlob: &servw6n.LargeBlobInputs{Write: []byte("wrapped key")}})
+	s.True(written.Extensions.LargeBlob.Written)
+	read := auth(&servw6n.Extensions{LargeBlob: &servw6n.LargeBlobInputs{Read: true}})
+	s.Equal([]byte("wrapped key"), read.Extensions.LargeBlob.Blob)
+
+	// Inputs that don't apply to the ceremony are rejected
+	for _, invalid := range []*servw6n.Extensions{
+		{PRF: &servw6n.PRFInputs{EvalByCredential: map[string]servw6n.PRFValues{"AA": {First: []byte("salt")}}}},
+		{LargeBlob: &servw6n.LargeBlobInputs{Read: true}},
+		{LargeBlob: &servw6n.LargeBlobInputs{Support: "always"}},
+	} {
+		_, err = client.EnrollInit(ctx, &servw6n.EnrollInitReq{User: &servw6n.EnrollUser{Id: "user-3"}, Extensions: invalid})
+		s.Error(err)
+	}
+	for _, invalid := range []*servw6n.Extensions{
+		{LargeBlob: &servw6n.LargeBlobInputs{Support: "required"}},
+		{LargeBlob: &servw6n.LargeBlobInputs{Read: true, Write: []byte("blob")}},
+		{LargeBlob: &servw6n.LargeBlobInputs{}},
+	} {
+		_, err = client.AuthInit(ctx, &servw6n.AuthInitReq{UserBlob: userBlob, Extensions: invalid})
+		s.Error(err)
+	}
+}
+
+func (s *IntegrationTestSuite) TestWebAuthnDiscoverableRateLimit() {
+	ctx := context.Background()
+	client := twofer.NewWebAuthnClient(s.twoferURL)
+
+	// Discoverable logins have no user, they are rate limited by the IP of the end user instead
+	for i := 0; i < 100; i++ {
+		_, err := client.AuthInit(ctx, &servw6n.AuthInitReq{EndUserIp: "192.0.2.1"})
+		s.Require().NoError(err)
+	}
+	_, err := client.AuthInit(ctx, &servw6n.AuthInitReq{EndUserIp: "192.0.2.1"})
+	s.Require().Error(err)
+	_, err = client.AuthInit(ctx, &servw6n.AuthInitReq{EndUserIp: "192.0.2.2"})
+	s.Require().NoError(err)
+}